@@ -1,6 +1,10 @@
 package cli
 
 import (
+	"fmt"
+	"sync"
+	"time"
+
 	"github.com/PremoWeb/Chadburn/core"
 	"github.com/PremoWeb/Chadburn/middlewares"
 	defaults "github.com/mcuadros/go-defaults"
@@ -13,6 +17,8 @@ const (
 	jobRun        = "job-run"
 	jobServiceRun = "job-service-run"
 	jobLocal      = "job-local"
+	jobSSH        = "job-ssh"
+	jobHTTP       = "job-http"
 )
 
 // Config contains the configuration
@@ -22,15 +28,46 @@ type Config struct {
 		middlewares.SaveConfig   `mapstructure:",squash"`
 		middlewares.MailConfig   `mapstructure:",squash"`
 		middlewares.GotifyConfig `mapstructure:",squash"`
+		LabelPrefix              string `gcfg:"label-prefix" mapstructure:"label-prefix"`
+		// InstanceID identifies the containers and services created by this
+		// instance, only its own leftovers are reconciled. Without it nothing
+		// is reconciled, as several instances could share the default one.
+		InstanceID        string `gcfg:"instance-id" mapstructure:"instance-id"`
+		OrphanPolicy      string `gcfg:"orphan-policy" mapstructure:"orphan-policy" default:"adopt"`
+		ReconcileInterval string `gcfg:"reconcile-interval" mapstructure:"reconcile-interval" default:"10m"`
+		// NotifyDockerHealth reports through the global middlewares when a
+		// docker daemon goes down and when it is back
+		NotifyDockerHealth bool `gcfg:"notify-docker-health" mapstructure:"notify-docker-health"`
 	}
-	ExecJobs      map[string]*ExecJobConfig    `gcfg:"job-exec" mapstructure:"job-exec,squash"`
-	RunJobs       map[string]*RunJobConfig     `gcfg:"job-run" mapstructure:"job-run,squash"`
-	ServiceJobs   map[string]*RunServiceConfig `gcfg:"job-service-run" mapstructure:"job-service-run,squash"`
-	LocalJobs     map[string]*LocalJobConfig   `gcfg:"job-local" mapstructure:"job-local,squash"`
+	ExecJobs    map[string]*ExecJobConfig    `gcfg:"job-exec" mapstructure:"job-exec,squash"`
+	RunJobs     map[string]*RunJobConfig     `gcfg:"job-run" mapstructure:"job-run,squash"`
+	ServiceJobs map[string]*RunServiceConfig `gcfg:"job-service-run" mapstructure:"job-service-run,squash"`
+	LocalJobs   map[string]*LocalJobConfig   `gcfg:"job-local" mapstructure:"job-local,squash"`
+	HTTPJobs    map[string]*HTTPJobConfig    `gcfg:"job-http" mapstructure:"job-http,squash"`
+
+	// Registries, docker hosts and ssh jobs can only be configured in the
+	// config file
+	SSHJobs     map[string]*SSHJobConfig        `gcfg:"job-ssh" mapstructure:"-"`
+	Registries  map[string]*core.RegistryConfig `gcfg:"registry" mapstructure:"-"`
+	DockerHosts map[string]*DockerHostConfig    `gcfg:"docker-host" mapstructure:"-"`
+
 	sh            *core.Scheduler
 	configHandler *FileConfigHandler
 	dockerHandler *DockerHandler
-	logger        core.Logger
+	// handlers of the docker hosts, by name
+	dockerHosts map[string]*DockerHandler
+	// labels of the containers of every docker host, the key of the docker
+	// host of the environment is empty
+	hostLabels map[string]map[string]map[string]string
+	// mu serializes the updates of the config file and the docker labels
+	mu            *sync.Mutex
+	labelPrefixes labelPrefixes
+	// names of the jobs defined in the config file, the docker labels can not
+	// define jobs with the same name and kind
+	fileJobs map[string]bool
+	// last docker labels report, used to log only the changes
+	lastLabelReport string
+	logger          core.Logger
 }
 
 func NewConfig(logger core.Logger) *Config {
@@ -40,6 +77,11 @@ func NewConfig(logger core.Logger) *Config {
 	c.RunJobs = make(map[string]*RunJobConfig)
 	c.ServiceJobs = make(map[string]*RunServiceConfig)
 	c.LocalJobs = make(map[string]*LocalJobConfig)
+	c.SSHJobs = make(map[string]*SSHJobConfig)
+	c.HTTPJobs = make(map[string]*HTTPJobConfig)
+	c.Registries = make(map[string]*core.RegistryConfig)
+	c.DockerHosts = make(map[string]*DockerHostConfig)
+	c.mu = &sync.Mutex{}
 	c.logger = logger
 	defaults.SetDefaults(c)
 	return c
@@ -65,6 +107,20 @@ func BuildFromString(config string, logger core.Logger) (*Config, error) {
 func (c *Config) InitializeApp(daemon *DaemonCommand) error {
 	c.sh = core.NewScheduler(c.logger)
 	c.buildSchedulerMiddlewares(c.sh)
+	core.SetRegistries(c.Registries)
+	core.SetInstanceID(c.Global.InstanceID)
+
+	if err := c.validateRegistries(); err != nil {
+		return err
+	}
+
+	if err := c.validateDockerHosts(); err != nil {
+		return err
+	}
+
+	if err := c.validateTemplates(); err != nil {
+		return err
+	}
 
 	var err error
 	c.configHandler, err = NewFileConfigHandler(daemon.ConfigFile, c, c.logger)
@@ -72,15 +128,35 @@ func (c *Config) InitializeApp(daemon *DaemonCommand) error {
 		return err
 	}
 
+	// the label prefix given as flag takes precedence over the global setting
+	if len(daemon.LabelPrefix) > 0 {
+		c.labelPrefixes = parseLabelPrefixes(daemon.LabelPrefix...)
+	} else {
+		c.labelPrefixes = parseLabelPrefixes(c.Global.LabelPrefix)
+	}
+
 	if !daemon.DisableDocker {
-		c.dockerHandler, err = NewDockerHandler(c, c.logger)
+		c.dockerHandler, err = NewDockerHandler(c, c.labelPrefixes, c.logger)
 		if err != nil {
 			return err
 		}
 
+		c.dockerHosts = make(map[string]*DockerHandler)
+		for name, host := range c.DockerHosts {
+			defaults.SetDefaults(host)
+			c.dockerHosts[name], err = NewDockerHostHandler(name, host, c, c.labelPrefixes, c.logger)
+			if err != nil {
+				return err
+			}
+		}
+
+		if err := c.startReconciler(); err != nil {
+			return err
+		}
+
 		for name, j := range c.ExecJobs {
 			defaults.SetDefaults(j)
-			j.Client = c.dockerHandler.GetInternalDockerClient()
+			j.Client = c.dockerClient(j.DockerHost)
 			j.Name = name
 			j.buildMiddlewares()
 			c.sh.AddJob(j)
@@ -88,7 +164,7 @@ func (c *Config) InitializeApp(daemon *DaemonCommand) error {
 
 		for name, j := range c.RunJobs {
 			defaults.SetDefaults(j)
-			j.Client = c.dockerHandler.GetInternalDockerClient()
+			j.Client = c.dockerClient(j.DockerHost)
 			j.Name = name
 			j.buildMiddlewares()
 			c.sh.AddJob(j)
@@ -97,7 +173,7 @@ func (c *Config) InitializeApp(daemon *DaemonCommand) error {
 		for name, j := range c.ServiceJobs {
 			defaults.SetDefaults(j)
 			j.Name = name
-			j.Client = c.dockerHandler.GetInternalDockerClient()
+			j.Client = c.dockerClient(j.DockerHost)
 			j.buildMiddlewares()
 			c.sh.AddJob(j)
 		}
@@ -110,9 +186,190 @@ func (c *Config) InitializeApp(daemon *DaemonCommand) error {
 		c.sh.AddJob(j)
 	}
 
+	for name, j := range c.HTTPJobs {
+		defaults.SetDefaults(j)
+		j.Name = name
+		j.buildMiddlewares()
+		c.sh.AddJob(j)
+	}
+
+	for name, j := range c.SSHJobs {
+		defaults.SetDefaults(j)
+		j.Name = name
+		j.buildMiddlewares()
+		c.sh.AddJob(j)
+	}
+
 	return nil
 }
 
+// startReconciler removes or adopts the containers and services left behind by
+// previous executions, at startup and periodically. It only runs with an
+// explicit instance-id, otherwise the instances sharing a docker daemon or a
+// swarm would remove the resources of each other.
+func (c *Config) startReconciler() error {
+	interval, err := time.ParseDuration(c.Global.ReconcileInterval)
+	if err != nil || interval <= 0 {
+		return fmt.Errorf("invalid reconcile-interval %q", c.Global.ReconcileInterval)
+	}
+
+	if c.Global.InstanceID == "" {
+		c.logger.Noticef("Leftover containers and services are not reconciled, set instance-id in [global] to enable it")
+		return nil
+	}
+
+	handlers := []*DockerHandler{c.dockerHandler}
+	for _, name := range sortedKeys(c.dockerHosts) {
+		handlers = append(handlers, c.dockerHosts[name])
+	}
+
+	for _, h := range handlers {
+		r, err := core.NewReconciler(h.GetInternalDockerClient(), c.Global.OrphanPolicy, c.logger)
+		if err != nil {
+			return err
+		}
+
+		go r.Watch(interval)
+	}
+
+	return nil
+}
+
+// validateRegistries checks the credentials of the registries
+func (c *Config) validateRegistries() error {
+	for _, name := range sortedKeys(c.Registries) {
+		if err := c.Registries[name].Validate(); err != nil {
+			return fmt.Errorf("registry %q: %s", name, err)
+		}
+	}
+
+	return nil
+}
+
+// validateDockerHosts checks the endpoints of the docker hosts and that the
+// jobs only use configured docker hosts
+func (c *Config) validateDockerHosts() error {
+	for _, name := range sortedKeys(c.DockerHosts) {
+		if _, err := c.DockerHosts[name].buildClient(); err != nil {
+			return fmt.Errorf("docker host %q: %s", name, err)
+		}
+	}
+
+	hosts := c.jobDockerHosts()
+	for _, job := range sortedKeys(hosts) {
+		if host := hosts[job]; host != "" && c.DockerHosts[host] == nil {
+			return fmt.Errorf("%s: unknown docker host %q", job, host)
+		}
+	}
+
+	return nil
+}
+
+// jobDockerHosts returns the docker host of the docker jobs, by name prefixed
+// by the job kind, empty for the one of the environment
+func (c *Config) jobDockerHosts() map[string]string {
+	hosts := make(map[string]string)
+	for name, j := range c.ExecJobs {
+		hosts[jobExec+"."+name] = j.DockerHost
+	}
+
+	for name, j := range c.RunJobs {
+		hosts[jobRun+"."+name] = j.DockerHost
+	}
+
+	for name, j := range c.ServiceJobs {
+		hosts[jobServiceRun+"."+name] = j.DockerHost
+	}
+
+	return hosts
+}
+
+// validateReload checks a reloaded config, its jobs can only use the docker
+// hosts connected at startup
+func (c *Config) validateReload(newConfig *Config) error {
+	if err := newConfig.validateRegistries(); err != nil {
+		return err
+	}
+
+	if err := newConfig.validateDockerHosts(); err != nil {
+		return err
+	}
+
+	if err := newConfig.validateTemplates(); err != nil {
+		return err
+	}
+
+	hosts := newConfig.jobDockerHosts()
+	for _, job := range sortedKeys(hosts) {
+		if host := hosts[job]; host != "" && c.dockerHosts[host] == nil {
+			return fmt.Errorf("%s: docker host %q is not connected, restart to add it", job, host)
+		}
+	}
+
+	return nil
+}
+
+// validateTemplates checks the templates of the commands, volumes and
+// environment variables of the jobs with templating enabled
+func (c *Config) validateTemplates() error {
+	templates := make(map[string][]string)
+	for name, j := range c.ExecJobs {
+		if j.Template {
+			templates[jobExec+"."+name] = append([]string{j.Command}, j.Environment...)
+		}
+	}
+
+	for name, j := range c.RunJobs {
+		if j.Template {
+			templates[jobRun+"."+name] = append(append([]string{j.Command}, j.Volume...), j.Environment...)
+		}
+	}
+
+	for name, j := range c.ServiceJobs {
+		if j.Template {
+			templates[jobServiceRun+"."+name] = append([]string{j.Command}, j.Environment...)
+		}
+	}
+
+	for name, j := range c.LocalJobs {
+		if j.Template {
+			templates[jobLocal+"."+name] = append([]string{j.Command}, j.Environment...)
+		}
+	}
+
+	for name, j := range c.SSHJobs {
+		if j.Template {
+			templates[jobSSH+"."+name] = []string{j.Command}
+		}
+	}
+
+	for _, job := range sortedKeys(templates) {
+		for _, t := range templates[job] {
+			if err := core.ValidateTemplate(t); err != nil {
+				return fmt.Errorf("%s: %s", job, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// dockerClient returns the client of the docker host with the given name, the
+// one of the environment if empty. The configs with jobs on unknown docker
+// hosts are rejected when loaded, see validateDockerHosts and validateReload.
+func (c *Config) dockerClient(host string) core.ContainerRuntime {
+	if host == "" {
+		return c.dockerHandler.GetInternalDockerClient()
+	}
+
+	h, ok := c.dockerHosts[host]
+	if !ok {
+		return nil
+	}
+
+	return h.GetInternalDockerClient()
+}
+
 func (c *Config) buildSchedulerMiddlewares(sh *core.Scheduler) {
 	sh.Use(middlewares.NewSlack(&c.Global.SlackConfig))
 	sh.Use(middlewares.NewSave(&c.Global.SaveConfig))
@@ -139,7 +396,7 @@ func (c *Config) updateJobs(newConfig *Config, isDockerLabels bool) {
 		if _, ok := newConfig.ExecJobs[name]; ok {
 			newJob := newConfig.ExecJobs[name]
 			defaults.SetDefaults(newJob)
-			newJob.Client = c.dockerHandler.GetInternalDockerClient()
+			newJob.Client = c.dockerClient(newJob.DockerHost)
 			newJob.Name = name
 			newJob.FromDockerLabel = isDockerLabels
 			if newJob.Hash() != j.Hash() || isClobalConfigUpdate {
@@ -159,9 +416,16 @@ func (c *Config) updateJobs(newConfig *Config, isDockerLabels bool) {
 
 	// Check for aditions
 	for newJobsName, newJob := range newConfig.ExecJobs {
-		if _, ok := c.ExecJobs[newJobsName]; !ok {
+		j, ok := c.ExecJobs[newJobsName]
+		// the jobs of the config file take precedence over the docker labels ones
+		if ok && !isDockerLabels && j.FromDockerLabel {
+			c.sh.RemoveJob(j)
+			ok = false
+		}
+
+		if !ok {
 			defaults.SetDefaults(newJob)
-			newJob.Client = c.dockerHandler.GetInternalDockerClient()
+			newJob.Client = c.dockerClient(newJob.DockerHost)
 			newJob.Name = newJobsName
 			newJob.FromDockerLabel = isDockerLabels
 			newJob.buildMiddlewares()
@@ -179,7 +443,7 @@ func (c *Config) updateJobs(newConfig *Config, isDockerLabels bool) {
 		if _, ok := newConfig.RunJobs[name]; ok {
 			newJob := newConfig.RunJobs[name]
 			defaults.SetDefaults(newJob)
-			newJob.Client = c.dockerHandler.GetInternalDockerClient()
+			newJob.Client = c.dockerClient(newJob.DockerHost)
 			newJob.Name = name
 			newJob.FromDockerLabel = isDockerLabels
 			if newJob.Hash() != j.Hash() || isClobalConfigUpdate {
@@ -199,9 +463,16 @@ func (c *Config) updateJobs(newConfig *Config, isDockerLabels bool) {
 
 	// Check for aditions
 	for newJobsName, newJob := range newConfig.RunJobs {
-		if _, ok := c.RunJobs[newJobsName]; !ok {
+		j, ok := c.RunJobs[newJobsName]
+		// the jobs of the config file take precedence over the docker labels ones
+		if ok && !isDockerLabels && j.FromDockerLabel {
+			c.sh.RemoveJob(j)
+			ok = false
+		}
+
+		if !ok {
 			defaults.SetDefaults(newJob)
-			newJob.Client = c.dockerHandler.GetInternalDockerClient()
+			newJob.Client = c.dockerClient(newJob.DockerHost)
 			newJob.Name = newJobsName
 			newJob.FromDockerLabel = isDockerLabels
 			newJob.buildMiddlewares()
@@ -219,7 +490,7 @@ func (c *Config) updateJobs(newConfig *Config, isDockerLabels bool) {
 		if _, ok := newConfig.ServiceJobs[name]; ok {
 			newJob := newConfig.ServiceJobs[name]
 			defaults.SetDefaults(newJob)
-			newJob.Client = c.dockerHandler.GetInternalDockerClient()
+			newJob.Client = c.dockerClient(newJob.DockerHost)
 			newJob.Name = name
 			newJob.FromDockerLabel = isDockerLabels
 			if newJob.Hash() != j.Hash() || isClobalConfigUpdate {
@@ -239,9 +510,16 @@ func (c *Config) updateJobs(newConfig *Config, isDockerLabels bool) {
 
 	// Check for aditions
 	for newJobsName, newJob := range newConfig.ServiceJobs {
-		if _, ok := c.ServiceJobs[newJobsName]; !ok {
+		j, ok := c.ServiceJobs[newJobsName]
+		// the jobs of the config file take precedence over the docker labels ones
+		if ok && !isDockerLabels && j.FromDockerLabel {
+			c.sh.RemoveJob(j)
+			ok = false
+		}
+
+		if !ok {
 			defaults.SetDefaults(newJob)
-			newJob.Client = c.dockerHandler.GetInternalDockerClient()
+			newJob.Client = c.dockerClient(newJob.DockerHost)
 			newJob.Name = newJobsName
 			newJob.FromDockerLabel = isDockerLabels
 			newJob.buildMiddlewares()
@@ -278,7 +556,14 @@ func (c *Config) updateJobs(newConfig *Config, isDockerLabels bool) {
 
 	// Check for aditions
 	for newJobsName, newJob := range newConfig.LocalJobs {
-		if _, ok := c.LocalJobs[newJobsName]; !ok {
+		j, ok := c.LocalJobs[newJobsName]
+		// the jobs of the config file take precedence over the docker labels ones
+		if ok && !isDockerLabels && j.FromDockerLabel {
+			c.sh.RemoveJob(j)
+			ok = false
+		}
+
+		if !ok {
 			defaults.SetDefaults(newJob)
 			newJob.Name = newJobsName
 			newJob.FromDockerLabel = isDockerLabels
@@ -287,30 +572,276 @@ func (c *Config) updateJobs(newConfig *Config, isDockerLabels bool) {
 			c.LocalJobs[newJobsName] = newJob
 		}
 	}
+
+	for name, j := range c.HTTPJobs {
+		// this prevents deletion of jobs that were added by reading a configuration file
+		if (isDockerLabels && !j.FromDockerLabel) || (!isDockerLabels && j.FromDockerLabel) {
+			continue
+		}
+
+		if _, ok := newConfig.HTTPJobs[name]; ok {
+			newJob := newConfig.HTTPJobs[name]
+			defaults.SetDefaults(newJob)
+			newJob.Name = name
+			newJob.FromDockerLabel = isDockerLabels
+			if newJob.Hash() != j.Hash() || isClobalConfigUpdate {
+				// Remove from the scheduler
+				c.sh.RemoveJob(j)
+				// Add the job back to the scheduler
+				newJob.buildMiddlewares()
+				c.sh.AddJob(newJob)
+				// Update the job config
+				c.HTTPJobs[name] = newJob
+			}
+		} else {
+			c.sh.RemoveJob(j)
+			delete(c.HTTPJobs, name)
+		}
+	}
+
+	// Check for aditions
+	for newJobsName, newJob := range newConfig.HTTPJobs {
+		j, ok := c.HTTPJobs[newJobsName]
+		// the jobs of the config file take precedence over the docker labels ones
+		if ok && !isDockerLabels && j.FromDockerLabel {
+			c.sh.RemoveJob(j)
+			ok = false
+		}
+
+		if !ok {
+			defaults.SetDefaults(newJob)
+			newJob.Name = newJobsName
+			newJob.FromDockerLabel = isDockerLabels
+			newJob.buildMiddlewares()
+			c.sh.AddJob(newJob)
+			c.HTTPJobs[newJobsName] = newJob
+		}
+	}
+
+	// the ssh jobs only come from the config file
+	if isDockerLabels {
+		return
+	}
+
+	for name, j := range c.SSHJobs {
+		if newJob, ok := newConfig.SSHJobs[name]; ok {
+			defaults.SetDefaults(newJob)
+			newJob.Name = name
+			if newJob.Hash() != j.Hash() || isClobalConfigUpdate {
+				c.sh.RemoveJob(j)
+				newJob.buildMiddlewares()
+				c.sh.AddJob(newJob)
+				c.SSHJobs[name] = newJob
+			}
+		} else {
+			c.sh.RemoveJob(j)
+			delete(c.SSHJobs, name)
+		}
+	}
+
+	for newJobsName, newJob := range newConfig.SSHJobs {
+		if _, ok := c.SSHJobs[newJobsName]; !ok {
+			defaults.SetDefaults(newJob)
+			newJob.Name = newJobsName
+			newJob.buildMiddlewares()
+			c.sh.AddJob(newJob)
+			c.SSHJobs[newJobsName] = newJob
+		}
+	}
 }
 
-func (c *Config) dockerLabelsUpdate(labels map[string]map[string]string) {
-	// Get the current labels
-	var parsedLabelConfig Config
-	parsedLabelConfig.buildFromDockerLabels(labels)
+func (c *Config) dockerLabelsUpdate(host string, labels map[string]map[string]string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
 
-	//labelsData, _ := json.Marshal(labels)
-	//c.logger.Debugf("dockerLabelsUpdate labels :%s", string(labelsData))
+	if c.hostLabels == nil {
+		c.hostLabels = make(map[string]map[string]map[string]string)
+	}
+	c.hostLabels[host] = labels
 
+	fileJobs := c.fileJobNames()
 	newConfig := NewConfig(c.logger)
-	newConfig.Global = parsedLabelConfig.Global
-	newConfig.ExecJobs = parsedLabelConfig.ExecJobs
-	newConfig.RunJobs = parsedLabelConfig.RunJobs
-	newConfig.LocalJobs = parsedLabelConfig.LocalJobs
-	newConfig.ServiceJobs = parsedLabelConfig.ServiceJobs
 
+	// the jobs of every docker host are built on their own, as container
+	// names are only unique in a host
+	var report labelReport
+	for _, h := range sortedKeys(c.hostLabels) {
+		// Get the current labels
+		var parsedLabelConfig Config
+		parsedLabelConfig.labelPrefixes = c.labelPrefixes
+		parsedLabelConfig.fileJobs = fileJobs
+		parsedLabelConfig.DockerHosts = c.DockerHosts
+		hostReport := parsedLabelConfig.buildFromDockerLabels(c.hostLabels[h])
+
+		if h == "" {
+			report = append(report, hostReport...)
+			newConfig.Global = parsedLabelConfig.Global
+			newConfig.ExecJobs = parsedLabelConfig.ExecJobs
+			newConfig.RunJobs = parsedLabelConfig.RunJobs
+			newConfig.LocalJobs = parsedLabelConfig.LocalJobs
+			newConfig.ServiceJobs = parsedLabelConfig.ServiceJobs
+			newConfig.HTTPJobs = parsedLabelConfig.HTTPJobs
+			continue
+		}
+
+		for _, d := range hostReport {
+			d.Container = h + "/" + d.Container
+			report = append(report, d)
+		}
+
+		// the jobs of a docker host run on it, prefixed by its name
+		for name, j := range parsedLabelConfig.ExecJobs {
+			j.DockerHost = h
+			newConfig.ExecJobs[h+"."+name] = j
+		}
+
+		for name, j := range parsedLabelConfig.RunJobs {
+			j.DockerHost = h
+			newConfig.RunJobs[h+"."+name] = j
+		}
+
+		for name, j := range parsedLabelConfig.ServiceJobs {
+			j.DockerHost = h
+			newConfig.ServiceJobs[h+"."+name] = j
+		}
+
+		for name := range parsedLabelConfig.LocalJobs {
+			report.add(h, "", "job %q ignored, %s jobs are not accepted from docker hosts", name, jobLocal)
+		}
+
+		for name := range parsedLabelConfig.HTTPJobs {
+			report.add(h, "", "job %q ignored, %s jobs are not accepted from docker hosts", name, jobHTTP)
+		}
+	}
+
+	c.reportDockerLabels(report)
 	c.updateJobs(newConfig, true)
 }
 
+// dockerHealthJob reports a change of the health of a docker daemon through
+// the global middlewares, as if it was the execution of a job
+type dockerHealthJob struct {
+	core.BareJob
+	err error
+}
+
+func (j *dockerHealthJob) Run(*core.Context) error {
+	return j.err
+}
+
+func (c *Config) dockerHealthUpdate(host string, err error) {
+	c.mu.Lock()
+	notify := c.Global.NotifyDockerHealth
+	c.mu.Unlock()
+
+	if !notify || c.sh == nil {
+		return
+	}
+
+	j := &dockerHealthJob{}
+	j.Name = "docker"
+	if host != "" {
+		j.Name = "docker-host." + host
+	}
+
+	j.Command = "ping"
+	if err != nil {
+		j.err = fmt.Errorf("docker unavailable: %s", err)
+	}
+
+	j.Use(c.sh.Middlewares()...)
+
+	ctx := core.NewContext(c.sh, j, core.NewExecution())
+	ctx.Start()
+	ctx.Stop(ctx.Next())
+}
+
+// fileJobNames returns the names, prefixed by the job kind, of the jobs defined
+// in the config file
+func (c *Config) fileJobNames() map[string]bool {
+	names := make(map[string]bool)
+	for name, j := range c.ExecJobs {
+		if !j.FromDockerLabel {
+			names[jobExec+"."+name] = true
+		}
+	}
+
+	for name, j := range c.RunJobs {
+		if !j.FromDockerLabel {
+			names[jobRun+"."+name] = true
+		}
+	}
+
+	for name, j := range c.ServiceJobs {
+		if !j.FromDockerLabel {
+			names[jobServiceRun+"."+name] = true
+		}
+	}
+
+	for name, j := range c.LocalJobs {
+		if !j.FromDockerLabel {
+			names[jobLocal+"."+name] = true
+		}
+	}
+
+	for name, j := range c.HTTPJobs {
+		if !j.FromDockerLabel {
+			names[jobHTTP+"."+name] = true
+		}
+	}
+
+	return names
+}
+
+// reportDockerLabels logs the diagnostics of the docker labels, only when they
+// differ from the previous ones, and exposes them as metrics
+func (c *Config) reportDockerLabels(report labelReport) {
+	DockerLabelIssues.Reset()
+	for container, count := range report.byContainer() {
+		DockerLabelIssues.WithLabelValues(container).Set(float64(count))
+	}
+
+	text := report.String()
+	if text == c.lastLabelReport {
+		return
+	}
+
+	c.lastLabelReport = text
+	if len(report) == 0 {
+		c.logger.Noticef("All docker labels are valid")
+		return
+	}
+
+	for _, d := range report {
+		c.logger.Warningf("Invalid docker label, %s", d)
+	}
+}
+
 func (c *Config) fileConfigUpdate(newConfig *Config) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.validateReload(newConfig); err != nil {
+		c.logger.Errorf("Config not reloaded, %s", err)
+		return
+	}
+
+	c.updateRegistries(newConfig.Registries)
 	c.updateJobs(newConfig, false)
 }
 
+// updateRegistries replaces the registry credentials, the jobs use them from
+// their next pull, so there is no need to reschedule them
+func (c *Config) updateRegistries(registries map[string]*core.RegistryConfig) {
+	if c.CompareHash(c.Registries, registries) {
+		return
+	}
+
+	c.Registries = registries
+	core.SetRegistries(registries)
+	c.logger.Noticef("Registry credentials updated")
+}
+
 func (c *Config) Hash(h interface{}) (uint64, error) {
 	hash, err := hashstructure.Hash(c, hashstructure.FormatV2, nil)
 	if err != nil {
@@ -406,6 +937,43 @@ func (c *LocalJobConfig) buildMiddlewares() {
 	c.LocalJob.Use(middlewares.NewGotify(&c.GotifyConfig))
 }
 
+// HTTPJobConfig contains all configuration params needed to build a HTTPJob
+type HTTPJobConfig struct {
+	core.HTTPJob              `mapstructure:",squash"`
+	middlewares.OverlapConfig `mapstructure:",squash"`
+	middlewares.SlackConfig   `mapstructure:",squash"`
+	middlewares.SaveConfig    `mapstructure:",squash"`
+	middlewares.MailConfig    `mapstructure:",squash"`
+	middlewares.GotifyConfig  `mapstructure:",squash"`
+	FromDockerLabel           bool `mapstructure:"fromDockerLabel"`
+}
+
+func (c *HTTPJobConfig) buildMiddlewares() {
+	c.HTTPJob.Use(middlewares.NewOverlap(&c.OverlapConfig))
+	c.HTTPJob.Use(middlewares.NewSlack(&c.SlackConfig))
+	c.HTTPJob.Use(middlewares.NewSave(&c.SaveConfig))
+	c.HTTPJob.Use(middlewares.NewMail(&c.MailConfig))
+	c.HTTPJob.Use(middlewares.NewGotify(&c.GotifyConfig))
+}
+
+// SSHJobConfig contains all configuration params needed to build a SSHJob
+type SSHJobConfig struct {
+	core.SSHJob               `mapstructure:",squash"`
+	middlewares.OverlapConfig `mapstructure:",squash"`
+	middlewares.SlackConfig   `mapstructure:",squash"`
+	middlewares.SaveConfig    `mapstructure:",squash"`
+	middlewares.MailConfig    `mapstructure:",squash"`
+	middlewares.GotifyConfig  `mapstructure:",squash"`
+}
+
+func (c *SSHJobConfig) buildMiddlewares() {
+	c.SSHJob.Use(middlewares.NewOverlap(&c.OverlapConfig))
+	c.SSHJob.Use(middlewares.NewSlack(&c.SlackConfig))
+	c.SSHJob.Use(middlewares.NewSave(&c.SaveConfig))
+	c.SSHJob.Use(middlewares.NewMail(&c.MailConfig))
+	c.SSHJob.Use(middlewares.NewGotify(&c.GotifyConfig))
+}
+
 func (c *RunServiceConfig) buildMiddlewares() {
 	c.RunServiceJob.Use(middlewares.NewOverlap(&c.OverlapConfig))
 	c.RunServiceJob.Use(middlewares.NewSlack(&c.SlackConfig))
@@ -45,6 +45,234 @@ func (s *SuiteConfig) TestBuildFromString(c *C) {
 	c.Assert(err, IsNil)
 }
 
+func (s *SuiteConfig) TestBuildFromStringRegistries(c *C) {
+	config, err := BuildFromString(`
+		[registry "ghcr.io"]
+		username = foo
+		password-file = /run/secrets/ghcr
+
+		[registry "quay.io"]
+		identity-token = bar
+
+		[registry "123456789012.dkr.ecr.eu-west-1.amazonaws.com"]
+		credential-helper = ecr-login
+  `, &TestLogger{})
+
+	c.Assert(err, IsNil)
+	c.Assert(config.Registries, DeepEquals, map[string]*core.RegistryConfig{
+		"ghcr.io": {Username: "foo", PasswordFile: "/run/secrets/ghcr"},
+		"quay.io": {IdentityToken: "bar"},
+		"123456789012.dkr.ecr.eu-west-1.amazonaws.com": {CredentialHelper: "ecr-login"},
+	})
+}
+
+func (s *SuiteConfig) TestValidateRegistries(c *C) {
+	config, err := BuildFromString(`
+		[registry "ecr"]
+		credential-helper = ecr-login
+		username = foo
+		password = bar
+  `, &TestLogger{})
+
+	c.Assert(err, IsNil)
+	c.Assert(config.validateRegistries(), ErrorMatches, `registry "ecr": credential-helper can not be used with .*`)
+
+	config.Registries["ecr"].Username = ""
+	config.Registries["ecr"].Password = ""
+	c.Assert(config.validateRegistries(), IsNil)
+
+	config.Registries["ghcr.io"] = &core.RegistryConfig{Password: "bar", PasswordFile: "/run/secrets/ghcr"}
+	c.Assert(config.validateRegistries(), ErrorMatches, `registry "ghcr.io": password and password-file can not be used together`)
+}
+
+func (s *SuiteConfig) TestBuildFromStringReconciler(c *C) {
+	config, err := BuildFromString(``, &TestLogger{})
+	c.Assert(err, IsNil)
+	c.Assert(config.Global.OrphanPolicy, Equals, core.OrphanPolicyAdopt)
+	c.Assert(config.Global.ReconcileInterval, Equals, "10m")
+
+	config, err = BuildFromString(`
+		[global]
+		instance-id = backups
+		orphan-policy = adopt
+		reconcile-interval = 1h
+  `, &TestLogger{})
+	c.Assert(err, IsNil)
+	c.Assert(config.Global.InstanceID, Equals, "backups")
+	c.Assert(config.Global.OrphanPolicy, Equals, core.OrphanPolicyAdopt)
+	c.Assert(config.Global.ReconcileInterval, Equals, "1h")
+}
+
+func (s *SuiteConfig) TestStartReconcilerWithoutInstanceID(c *C) {
+	config, err := BuildFromString(`
+		[global]
+		reconcile-interval = 1h
+  `, &TestLogger{})
+	c.Assert(err, IsNil)
+
+	// without instance-id the reconciler does not start, the docker handlers
+	// are not even looked at
+	c.Assert(config.startReconciler(), IsNil)
+}
+
+func (s *SuiteConfig) TestBuildFromStringSSHJobs(c *C) {
+	config, err := BuildFromString(`
+		[job-ssh "logrotate"]
+		schedule = @daily
+		host = legacy01.example.com
+		user = deploy
+		key-file = /keys/id_ed25519
+		known-hosts = /keys/known_hosts
+		command = /usr/sbin/logrotate /etc/logrotate.conf
+  `, &TestLogger{})
+	c.Assert(err, IsNil)
+
+	j := config.SSHJobs["logrotate"]
+	defaults.SetDefaults(j)
+	c.Assert(j.Host, Equals, "legacy01.example.com")
+	c.Assert(j.Port, Equals, 22)
+	c.Assert(j.User, Equals, "deploy")
+	c.Assert(j.KeyFile, Equals, "/keys/id_ed25519")
+	c.Assert(j.KnownHosts, Equals, "/keys/known_hosts")
+	c.Assert(j.StrictHostKeyChecking, Equals, "true")
+	c.Assert(j.Command, Equals, "/usr/sbin/logrotate /etc/logrotate.conf")
+}
+
+func (s *SuiteConfig) TestUpdateSSHJobs(c *C) {
+	config := NewConfig(&TestLogger{})
+	config.sh = core.NewScheduler(&TestLogger{})
+
+	update := func(ini string) {
+		newConfig, err := BuildFromString(ini, &TestLogger{})
+		c.Assert(err, IsNil)
+		config.fileConfigUpdate(newConfig)
+	}
+
+	update(`
+		[job-ssh "logrotate"]
+		schedule = @daily
+		host = legacy01
+		command = logrotate /etc/logrotate.conf
+  `)
+	c.Assert(config.SSHJobs, HasLen, 1)
+
+	update(`
+		[job-ssh "logrotate"]
+		schedule = @daily
+		host = legacy02
+		command = logrotate /etc/logrotate.conf
+  `)
+	c.Assert(config.SSHJobs["logrotate"].Host, Equals, "legacy02")
+
+	// the docker labels do not touch the ssh jobs
+	config.dockerLabelsUpdate("", nil)
+	c.Assert(config.SSHJobs, HasLen, 1)
+
+	update(``)
+	c.Assert(config.SSHJobs, HasLen, 0)
+}
+
+func (s *SuiteConfig) TestUpdateRunJobs(c *C) {
+	config := NewConfig(&TestLogger{})
+	config.sh = core.NewScheduler(&TestLogger{})
+	config.dockerHandler = &DockerHandler{}
+
+	update := func(image string) {
+		config.dockerLabelsUpdate("", map[string]map[string]string{
+			"chadburn": {
+				requiredLabel: "true",
+				serviceLabel:  "true",
+				labelPrefix + "." + jobRun + ".backup.schedule": "@daily",
+				labelPrefix + "." + jobRun + ".backup.image":    image,
+			},
+		})
+	}
+
+	update("postgres:15")
+	job := config.RunJobs["backup"]
+	c.Assert(job.Image, Equals, "postgres:15")
+
+	// the same labels keep the scheduled job
+	update("postgres:15")
+	c.Assert(config.RunJobs["backup"], Equals, job)
+
+	// a new image reschedules it
+	update("postgres:16")
+	c.Assert(config.RunJobs["backup"], Not(Equals), job)
+	c.Assert(config.RunJobs["backup"].Image, Equals, "postgres:16")
+}
+
+func (s *SuiteConfig) TestBuildFromStringScript(c *C) {
+	config, err := BuildFromString(`
+		[job-local "backup"]
+		schedule = @daily
+		script = set -e
+		script = tar czf /backups/data.tgz /data
+		script = "find /backups -mtime +7 -delete; echo done"
+
+		[job-exec "cache"]
+		schedule = @hourly
+		container = php
+		shell = /bin/bash -e
+		script = cd /var/www && php artisan cache:clear
+  `, &TestLogger{})
+	c.Assert(err, IsNil)
+
+	local := config.LocalJobs["backup"]
+	defaults.SetDefaults(local)
+	c.Assert(local.Shell, Equals, "/bin/sh")
+	c.Assert(local.Script, DeepEquals, []string{
+		"set -e",
+		"tar czf /backups/data.tgz /data",
+		"find /backups -mtime +7 -delete; echo done",
+	})
+
+	exec := config.ExecJobs["cache"]
+	c.Assert(exec.Shell, Equals, "/bin/bash -e")
+	c.Assert(exec.Script, DeepEquals, []string{"cd /var/www && php artisan cache:clear"})
+}
+
+func (s *SuiteConfig) TestBuildFromStringLocalIsolation(c *C) {
+	config, err := BuildFromString(`
+		[job-local "backup"]
+		schedule = @daily
+		command = /usr/local/bin/backup
+		user = backup
+		group = disk
+		cpu-time = 30m
+		memory = 512m
+		open-files = 1024
+		cgroup = /sys/fs/cgroup/chadburn.slice
+  `, &TestLogger{})
+	c.Assert(err, IsNil)
+
+	j := config.LocalJobs["backup"]
+	c.Assert(j.User, Equals, "backup")
+	c.Assert(j.Group, Equals, "disk")
+	c.Assert(j.CPUTime, Equals, "30m")
+	c.Assert(j.Memory, Equals, "512m")
+	c.Assert(j.OpenFiles, Equals, 1024)
+	c.Assert(j.Cgroup, Equals, "/sys/fs/cgroup/chadburn.slice")
+}
+
+func (s *SuiteConfig) TestBuildFromStringLocalEnvironment(c *C) {
+	config, err := BuildFromString(`
+		[job-local "report"]
+		schedule = @daily
+		command = /usr/local/bin/report
+		environment = REPORT_FORMAT=pdf
+		environment = REPORT_LANG=en
+		env-file = /etc/chadburn/report.env
+		inherit-env = PATH,TZ
+  `, &TestLogger{})
+	c.Assert(err, IsNil)
+
+	j := config.LocalJobs["report"]
+	c.Assert(j.Environment, DeepEquals, []string{"REPORT_FORMAT=pdf", "REPORT_LANG=en"})
+	c.Assert(j.EnvFile, Equals, "/etc/chadburn/report.env")
+	c.Assert(j.InheritEnv, Equals, "PATH,TZ")
+}
+
 func (s *SuiteConfig) TestJobDefaultsSet(c *C) {
 	j := &RunJobConfig{}
 	j.Pull = "false"
@@ -273,8 +501,367 @@ func (s *SuiteConfig) TestLabelsConfig(c *C) {
 
 	for _, t := range testcases {
 		var conf = Config{}
-		err := conf.buildFromDockerLabels(t.Labels)
-		c.Assert(err, IsNil)
+		conf.buildFromDockerLabels(t.Labels)
 		c.Assert(conf, DeepEquals, t.ExpectedConfig)
 	}
 }
+
+func (s *SuiteConfig) TestLabelsExecEnvironment(c *C) {
+	var conf Config
+	report := conf.buildFromDockerLabels(map[string]map[string]string{
+		"some": {
+			requiredLabel: "true",
+			serviceLabel:  "true",
+			labelPrefix + "." + jobExec + ".job1.schedule":    "schedule1",
+			labelPrefix + "." + jobExec + ".job1.container":   "app",
+			labelPrefix + "." + jobExec + ".job1.command":     "command1",
+			labelPrefix + "." + jobExec + ".job1.environment": `["FOO=foo bar", "BAR=bar"]`,
+			labelPrefix + "." + jobExec + ".job1.env-file":    "/run/secrets/job.env",
+			labelPrefix + "." + jobExec + ".job1.workdir":     "/app",
+			labelPrefix + "." + jobExec + ".job1.privileged":  "true",
+			labelPrefix + "." + jobExec + ".job2.schedule":    "schedule2",
+			labelPrefix + "." + jobExec + ".job2.command":     "command2",
+			labelPrefix + "." + jobExec + ".job2.environment": "FOO=foo",
+		},
+	})
+	c.Assert(report, HasLen, 0)
+
+	job := conf.ExecJobs["job1"]
+	c.Assert(job.Environment, DeepEquals, []string{"FOO=foo bar", "BAR=bar"})
+	c.Assert(job.EnvFile, Equals, "/run/secrets/job.env")
+	c.Assert(job.WorkingDir, Equals, "/app")
+	c.Assert(job.Privileged, Equals, true)
+	c.Assert(conf.ExecJobs["job2"].Environment, DeepEquals, []string{"FOO=foo"})
+
+	// a container other than the service one can not read files of chadburn
+	// or run privileged commands
+	conf = Config{}
+	report = conf.buildFromDockerLabels(map[string]map[string]string{
+		"other": {
+			requiredLabel: "true",
+			labelPrefix + "." + jobExec + ".job3.schedule":   "schedule3",
+			labelPrefix + "." + jobExec + ".job3.command":    "command3",
+			labelPrefix + "." + jobExec + ".job3.env-file":   "/etc/shadow",
+			labelPrefix + "." + jobExec + ".job3.privileged": "true",
+		},
+	})
+	c.Assert(report, HasLen, 2)
+	c.Assert(report[0].Container, Equals, "other")
+	c.Assert(report[0].Label, Equals, labelPrefix+"."+jobExec+".job3.env-file")
+	c.Assert(report[0].Reason, Equals, "ignored, env-file is only accepted on the service container")
+	c.Assert(report[1].Label, Equals, labelPrefix+"."+jobExec+".job3.privileged")
+
+	job = conf.ExecJobs["job3"]
+	c.Assert(job.Container, Equals, "other")
+	c.Assert(job.EnvFile, Equals, "")
+	c.Assert(job.Privileged, Equals, false)
+}
+
+func (s *SuiteConfig) TestValidateTemplates(c *C) {
+	config, err := BuildFromString(`
+		[job-run "backup"]
+		schedule = @daily
+		image = postgres
+		template = true
+		command = "pg_dump -f /backups/dump-{{ date \"2006-01-02\" .ScheduledTime }}.sql"
+		volume = /srv/backups/{{ .Hostname }}:/backups
+
+		[job-local "report"]
+		schedule = @daily
+		template = true
+		command = /usr/local/bin/report
+		environment = REPORT_NAME={{ .Name }}
+
+		[job-exec "list"]
+		schedule = @daily
+		container = docker
+		command = docker ps --format {{.Names}}
+  `, &TestLogger{})
+	c.Assert(err, IsNil)
+	c.Assert(config.RunJobs["backup"].Command, Equals, `pg_dump -f /backups/dump-{{ date "2006-01-02" .ScheduledTime }}.sql`)
+	c.Assert(config.RunJobs["backup"].Template, Equals, true)
+	c.Assert(config.validateTemplates(), ErrorMatches, `job-local.report: invalid template "REPORT_NAME={{ .Name }}": .*`)
+
+	// the values of the jobs without templating are not templates
+	config.LocalJobs["report"].Environment = []string{"REPORT_NAME={{ .Job }}"}
+	c.Assert(config.validateTemplates(), IsNil)
+}
+
+func (s *SuiteConfig) TestReloadInvalidTemplate(c *C) {
+	config := NewConfig(&TestLogger{})
+	config.sh = core.NewScheduler(&TestLogger{})
+
+	update := func(command string) {
+		newConfig, err := BuildFromString(`
+			[job-local "report"]
+			schedule = @daily
+			template = true
+			command = `+command, &TestLogger{})
+		c.Assert(err, IsNil)
+		config.fileConfigUpdate(newConfig)
+	}
+
+	update("report --job {{ .Job }}")
+	c.Assert(config.LocalJobs["report"].Command, Equals, "report --job {{ .Job }}")
+
+	// the reload is rejected, the previous jobs are kept
+	update("report --job {{ .Name }}")
+	c.Assert(config.LocalJobs["report"].Command, Equals, "report --job {{ .Job }}")
+}
+
+func (s *SuiteConfig) TestLabelsTemplates(c *C) {
+	var conf Config
+	report := conf.buildFromDockerLabels(map[string]map[string]string{
+		"some": {
+			requiredLabel: "true",
+			labelPrefix + "." + jobExec + ".job1.schedule":    "schedule1",
+			labelPrefix + "." + jobExec + ".job1.template":    "true",
+			labelPrefix + "." + jobExec + ".job1.command":     "dump --date {{ .ScheduledTime.Format \"2006-01-02\" }}",
+			labelPrefix + "." + jobExec + ".job2.schedule":    "schedule2",
+			labelPrefix + "." + jobExec + ".job2.template":    "true",
+			labelPrefix + "." + jobExec + ".job2.command":     "dump",
+			labelPrefix + "." + jobExec + ".job2.environment": `["FOO=foo", "BAR={{ .Bar }}"]`,
+			labelPrefix + "." + jobExec + ".job3.schedule":    "schedule3",
+			labelPrefix + "." + jobExec + ".job3.command":     "docker ps --format {{.Names}}",
+		},
+	})
+
+	c.Assert(conf.ExecJobs, HasLen, 2)
+	c.Assert(conf.ExecJobs["job1"].Template, Equals, true)
+	c.Assert(conf.ExecJobs["job3"].Template, Equals, false)
+	c.Assert(conf.ExecJobs["job3"].Command, Equals, "docker ps --format {{.Names}}")
+	c.Assert(report, HasLen, 1)
+	c.Assert(report[0].Label, Equals, labelPrefix+"."+jobExec+".job2.environment")
+	c.Assert(report[0].Reason, Matches, `rejected: invalid template "BAR={{ .Bar }}": .*`)
+}
+
+func (s *SuiteConfig) TestBuildFromStringHTTPJobs(c *C) {
+	config, err := BuildFromString(`
+		[job-http "cleanup"]
+		schedule = @hourly
+		method = POST
+		url = https://app.example.com/hooks/cleanup
+		header = Content-Type: application/json
+		header = Authorization: Bearer secret
+		body = "{\"days\":30}"
+		timeout = 10s
+		expect-status = 200
+		expect-status = 204
+		expect-body = "\"status\": *\"ok\""
+  `, &TestLogger{})
+	c.Assert(err, IsNil)
+
+	j := config.HTTPJobs["cleanup"]
+	c.Assert(j.Method, Equals, "POST")
+	c.Assert(j.URL, Equals, "https://app.example.com/hooks/cleanup")
+	c.Assert(j.Header, DeepEquals, []string{"Content-Type: application/json", "Authorization: Bearer secret"})
+	c.Assert(j.Body, Equals, `{"days":30}`)
+	c.Assert(j.Timeout, Equals, "10s")
+	c.Assert(j.ExpectStatus, DeepEquals, []string{"200", "204"})
+	c.Assert(j.ExpectBody, Equals, `"status": *"ok"`)
+	c.Assert(j.GetCommand(), Equals, "POST https://app.example.com/hooks/cleanup")
+}
+
+func (s *SuiteConfig) TestLabelsHTTPJobs(c *C) {
+	var conf Config
+	report := conf.buildFromDockerLabels(map[string]map[string]string{
+		"chadburn": {
+			requiredLabel: "true",
+			serviceLabel:  "true",
+			labelPrefix + "." + jobHTTP + ".cleanup.schedule":      "@hourly",
+			labelPrefix + "." + jobHTTP + ".cleanup.url":           "http://app/hooks/cleanup",
+			labelPrefix + "." + jobHTTP + ".cleanup.header":        `["X-Token: foo", "Accept: text/plain"]`,
+			labelPrefix + "." + jobHTTP + ".cleanup.expect-status": "2xx",
+		},
+		"app": {
+			requiredLabel: "true",
+			labelPrefix + "." + jobHTTP + ".ping.schedule": "@hourly",
+		},
+	})
+
+	c.Assert(report, HasLen, 1)
+	c.Assert(report[0].Reason, Equals, "ignored, job-http jobs are only accepted on the service container")
+
+	j := conf.HTTPJobs["cleanup"]
+	defaults.SetDefaults(j)
+	c.Assert(j.Method, Equals, "GET")
+	c.Assert(j.URL, Equals, "http://app/hooks/cleanup")
+	c.Assert(j.Header, DeepEquals, []string{"X-Token: foo", "Accept: text/plain"})
+	c.Assert(j.ExpectStatus, DeepEquals, []string{"2xx"})
+}
+
+func (s *SuiteConfig) TestParseLabelPrefixes(c *C) {
+	c.Assert(parseLabelPrefixes(), DeepEquals, labelPrefixes{labelPrefix})
+	c.Assert(parseLabelPrefixes(""), DeepEquals, labelPrefixes{labelPrefix})
+	c.Assert(parseLabelPrefixes("chadburn"), DeepEquals, labelPrefixes{"chadburn"})
+	c.Assert(parseLabelPrefixes("chadburn, ofelia", "chadburn"), DeepEquals, labelPrefixes{"chadburn", "ofelia"})
+	c.Assert(parseLabelPrefixes("com.example.cron."), DeepEquals, labelPrefixes{"com.example.cron"})
+}
+
+func (s *SuiteConfig) TestLabelsConfigPrefixes(c *C) {
+	conf := Config{labelPrefixes: parseLabelPrefixes("chadburn,ofelia")}
+	report := conf.buildFromDockerLabels(map[string]map[string]string{
+		"some": {
+			"chadburn.enabled":                "true",
+			"chadburn.job-exec.job1.schedule": "schedule1",
+			"chadburn.job-exec.job1.command":  "command1",
+		},
+		"other": {
+			"ofelia.enabled":                       "true",
+			"ofelia.job-exec.job2.schedule":        "schedule2",
+			"ofelia.job-exec.job2.command":         "command2",
+			labelPrefix + ".job-exec.job3.command": "command3",
+		},
+	})
+	c.Assert(report, HasLen, 0)
+	c.Assert(conf.ExecJobs, HasLen, 2)
+	c.Assert(conf.ExecJobs["job1"].Container, Equals, "some")
+	c.Assert(conf.ExecJobs["job1"].Command, Equals, "command1")
+	c.Assert(conf.ExecJobs["job2"].Container, Equals, "other")
+	c.Assert(conf.ExecJobs["job2"].Schedule, Equals, "schedule2")
+}
+
+func (s *SuiteConfig) TestLabelsReport(c *C) {
+	var conf Config
+	report := conf.buildFromDockerLabels(map[string]map[string]string{
+		"some": {
+			requiredLabel:                                    "true",
+			serviceLabel:                                     "true",
+			labelPrefix + ".slack-webhook":                   "http://example.com",
+			labelPrefix + ".slack-webhok":                    "http://example.com",
+			labelPrefix + "." + jobExec + ".job1.schedule":   "schedule1",
+			labelPrefix + "." + jobExec + ".job1.schedul":    "schedule1",
+			labelPrefix + "." + jobExec + ".job2.schedule":   "schedule2",
+			labelPrefix + "." + jobExec + ".job2.no-overlap": "maybe",
+			labelPrefix + ".job-foo.job3.schedule":           "schedule3",
+			labelPrefix + "." + jobRun + ".job4":             "schedule4",
+		},
+		"other": {
+			requiredLabel: "true",
+			labelPrefix + "." + jobRun + ".job5.schedule": "schedule5",
+			labelPrefix + ".slack-webhook":                "http://example.com",
+		},
+	})
+
+	c.Assert(conf.Global.SlackWebhook, Equals, "http://example.com")
+	c.Assert(conf.ExecJobs, HasLen, 1)
+	c.Assert(conf.ExecJobs["job1"].Schedule, Equals, "schedule1")
+	c.Assert(conf.RunJobs, HasLen, 0)
+
+	c.Assert(report, HasLen, 7)
+	c.Assert(report[0].Container, Equals, "other")
+	c.Assert(report[0].Label, Equals, labelPrefix+"."+jobRun+".job5.schedule")
+	c.Assert(report[1].Label, Equals, labelPrefix+".slack-webhook")
+	c.Assert(report[2].Label, Equals, labelPrefix+".job-foo.job3.schedule")
+	c.Assert(report[3].Label, Equals, labelPrefix+"."+jobRun+".job4")
+	c.Assert(report[4].Label, Equals, labelPrefix+".slack-webhok")
+	c.Assert(report[4].Reason, Equals, `unknown global setting "slack-webhok"`)
+	c.Assert(report[5].Label, Equals, labelPrefix+"."+jobExec+".job1.schedul")
+	c.Assert(report[5].Reason, Equals, `unknown parameter "schedul"`)
+	c.Assert(report[6].Reason, Equals, `job "job2" rejected: cannot parse 'no-overlap' as bool: strconv.ParseBool: parsing "maybe": invalid syntax`)
+	c.Assert(report.byContainer(), DeepEquals, map[string]int{"some": 5, "other": 2})
+}
+
+func (s *SuiteConfig) TestLabelsReportGlobalOrigin(c *C) {
+	var conf Config
+	report := conf.buildFromDockerLabels(map[string]map[string]string{
+		"a": {
+			requiredLabel:                  "true",
+			serviceLabel:                   "true",
+			labelPrefix + ".slack-webhook": "http://example.com",
+		},
+		"b": {
+			requiredLabel:                         "true",
+			serviceLabel:                          "true",
+			labelPrefix + ".notify-docker-health": "maybe",
+		},
+	})
+
+	c.Assert(conf.Global.SlackWebhook, Equals, "http://example.com")
+	c.Assert(report, HasLen, 1)
+	c.Assert(report[0].Container, Equals, "b")
+	c.Assert(report[0].Label, Equals, labelPrefix+".notify-docker-health")
+	c.Assert(report[0].Reason, Matches, "global setting rejected: .*notify-docker-health.*")
+}
+
+func (s *SuiteConfig) TestLabelsDuplicatedJobs(c *C) {
+	replicas := func(params map[string]string) map[string]map[string]string {
+		labels := make(map[string]map[string]string)
+		for _, name := range []string{"proj-php-2", "proj-php-1"} {
+			labels[name] = map[string]string{
+				requiredLabel:                "true",
+				"com.docker.compose.project": "proj",
+			}
+
+			for k, v := range params {
+				labels[name][labelPrefix+"."+jobExec+".cleanup."+k] = v
+			}
+		}
+
+		return labels
+	}
+
+	var conf Config
+	report := conf.buildFromDockerLabels(replicas(map[string]string{"schedule": "@hourly"}))
+	c.Assert(conf.ExecJobs, HasLen, 1)
+	c.Assert(conf.ExecJobs["cleanup"].Container, Equals, "proj-php-1")
+	c.Assert(report, HasLen, 1)
+	c.Assert(report[0].Container, Equals, "proj-php-2")
+
+	conf = Config{}
+	report = conf.buildFromDockerLabels(replicas(map[string]string{"schedule": "@hourly", "on-duplicate": "one"}))
+	c.Assert(conf.ExecJobs, HasLen, 1)
+	c.Assert(conf.ExecJobs["cleanup"].Container, Equals, "proj-php-1")
+	c.Assert(report, HasLen, 0)
+
+	conf = Config{}
+	report = conf.buildFromDockerLabels(replicas(map[string]string{"schedule": "@hourly", "on-duplicate": "all"}))
+	c.Assert(report, HasLen, 0)
+	c.Assert(conf.ExecJobs, HasLen, 2)
+	c.Assert(conf.ExecJobs["cleanup.proj-php-1"].Container, Equals, "proj-php-1")
+	c.Assert(conf.ExecJobs["cleanup.proj-php-2"].Container, Equals, "proj-php-2")
+
+	conf = Config{}
+	report = conf.buildFromDockerLabels(replicas(map[string]string{"schedule": "@hourly", "on-duplicate": "reject"}))
+	c.Assert(conf.ExecJobs, HasLen, 0)
+	c.Assert(report, HasLen, 2)
+
+	conf = Config{}
+	report = conf.buildFromDockerLabels(replicas(map[string]string{"schedule": "@hourly", "namespace": "container"}))
+	c.Assert(report, HasLen, 0)
+	c.Assert(conf.ExecJobs, HasLen, 2)
+	c.Assert(conf.ExecJobs["proj-php-1.cleanup"].Container, Equals, "proj-php-1")
+
+	conf = Config{}
+	report = conf.buildFromDockerLabels(replicas(map[string]string{"schedule": "@hourly", "namespace": "project", "on-duplicate": "one"}))
+	c.Assert(report, HasLen, 0)
+	c.Assert(conf.ExecJobs, HasLen, 1)
+	c.Assert(conf.ExecJobs["proj.cleanup"].Container, Equals, "proj-php-1")
+
+	// the values of the first container by name apply, the others are reported
+	labels := replicas(map[string]string{"schedule": "@hourly", "on-duplicate": "all"})
+	labels["proj-php-2"][labelPrefix+"."+jobExec+".cleanup.on-duplicate"] = "reject"
+	delete(labels["proj-php-1"], labelPrefix+"."+jobExec+".cleanup.on-duplicate")
+	labels["proj-php-3"] = map[string]string{
+		requiredLabel: "true",
+		labelPrefix + "." + jobExec + ".cleanup.schedule":     "@hourly",
+		labelPrefix + "." + jobExec + ".cleanup.on-duplicate": "all",
+	}
+	for i := 0; i < 5; i++ {
+		conf = Config{}
+		report = conf.buildFromDockerLabels(labels)
+		c.Assert(conf.ExecJobs, HasLen, 1)
+		c.Assert(conf.ExecJobs["cleanup"].Container, Equals, "proj-php-1")
+		c.Assert(report, HasLen, 4)
+		c.Assert(report[0].Container, Equals, "proj-php-2")
+		c.Assert(report[0].Label, Equals, labelPrefix+"."+jobExec+".cleanup.on-duplicate")
+		c.Assert(report[0].Reason, Equals, `value "reject" of job "cleanup" ignored, container "proj-php-1" sets ""`)
+		c.Assert(report[1].Container, Equals, "proj-php-3")
+		c.Assert(report[1].Reason, Equals, `value "all" of job "cleanup" ignored, container "proj-php-1" sets ""`)
+	}
+
+	conf = Config{fileJobs: map[string]bool{jobExec + ".cleanup": true}}
+	report = conf.buildFromDockerLabels(replicas(map[string]string{"schedule": "@hourly", "on-duplicate": "one"}))
+	c.Assert(conf.ExecJobs, HasLen, 0)
+	c.Assert(report, HasLen, 1)
+}
@@ -14,10 +14,11 @@ import (
 
 // DaemonCommand daemon process
 type DaemonCommand struct {
-	ConfigFile    string `long:"config" description:"configuration file" default:"/etc/chadburn.conf"`
-	Metrics       bool   `long:"metrics" description:"Enable Prometheus compatible metrics endpoint"`
-	MetricsAddr   string `long:"listen-address" description:"Metrics endpoint listen address." default:":8080"`
-	DisableDocker bool   `long:"disable-docker" description:"Disable docker integration. All job kinds except 'job-local' will be ignored"`
+	ConfigFile    string   `long:"config" description:"configuration file" default:"/etc/chadburn.conf"`
+	Metrics       bool     `long:"metrics" description:"Enable Prometheus compatible metrics endpoint"`
+	MetricsAddr   string   `long:"listen-address" description:"Metrics endpoint listen address." default:":8080"`
+	DisableDocker bool     `long:"disable-docker" description:"Disable docker integration. All job kinds except 'job-local' will be ignored"`
+	LabelPrefix   []string `long:"label-prefix" description:"Prefix of the docker labels (default: scheduler). Can be repeated or comma separated to accept several prefixes, e.g. chadburn,ofelia"`
 	scheduler     *core.Scheduler
 	signals       chan os.Signal
 	done          chan bool
@@ -2,126 +2,551 @@ package cli
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
 	"strings"
 
+	"github.com/PremoWeb/Chadburn/core"
 	"github.com/mitchellh/mapstructure"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
 )
 
 const (
+	// labelPrefix is the default prefix of the docker labels, it can be
+	// changed using the `label-prefix` flag or global setting
 	labelPrefix = "scheduler"
 
-	requiredLabel       = labelPrefix + ".enabled"
-	requiredLabelFilter = requiredLabel + "=true"
-	serviceLabel        = labelPrefix + ".service"
+	enabledLabelName = "enabled"
+	serviceLabelName = "service"
+
+	requiredLabel = labelPrefix + "." + enabledLabelName
+	serviceLabel  = labelPrefix + "." + serviceLabelName
+)
+
+// DockerLabelIssues is the number of docker labels rejected or ignored on
+// every container
+var DockerLabelIssues = promauto.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "chadburn_docker_label_issues",
+		Help: "Number of docker labels rejected or ignored on a container.",
+	},
+	[]string{"container"},
 )
 
-func (c *Config) buildFromDockerLabels(labels map[string]map[string]string) error {
-	execJobs := make(map[string]map[string]interface{})
-	localJobs := make(map[string]map[string]interface{})
-	runJobs := make(map[string]map[string]interface{})
-	serviceJobs := make(map[string]map[string]interface{})
+// labelPrefixes is the list of docker label prefixes accepted by chadburn,
+// e.g. `chadburn` and `ofelia` while migrating from Ofelia
+type labelPrefixes []string
+
+// parseLabelPrefixes builds the prefixes list from the given values, every
+// value may hold several comma separated prefixes. If no prefix is given the
+// default one is used.
+func parseLabelPrefixes(values ...string) labelPrefixes {
+	var p labelPrefixes
+	for _, v := range values {
+		for _, prefix := range strings.Split(v, ",") {
+			prefix = strings.Trim(strings.TrimSpace(prefix), ".")
+			if prefix == "" || contains(p, prefix) {
+				continue
+			}
+
+			p = append(p, prefix)
+		}
+	}
+
+	if len(p) == 0 {
+		return labelPrefixes{labelPrefix}
+	}
+
+	return p
+}
+
+// trim returns the label without the matching prefix
+func (p labelPrefixes) trim(label string) (string, bool) {
+	for _, prefix := range p {
+		if strings.HasPrefix(label, prefix+".") {
+			return label[len(prefix)+1:], true
+		}
+	}
+
+	return "", false
+}
+
+func (p labelPrefixes) String() string {
+	return strings.Join(p, ",")
+}
+
+// labelDiagnostic describes a docker label rejected or ignored while building
+// the configuration
+type labelDiagnostic struct {
+	Container string
+	Label     string
+	Reason    string
+}
+
+func (d labelDiagnostic) String() string {
+	if d.Label == "" {
+		return fmt.Sprintf("container %q: %s", d.Container, d.Reason)
+	}
+
+	return fmt.Sprintf("container %q: label %q %s", d.Container, d.Label, d.Reason)
+}
+
+// labelReport contains all the diagnostics found on the docker labels
+type labelReport []labelDiagnostic
+
+func (r *labelReport) add(container, label, reason string, args ...interface{}) {
+	*r = append(*r, labelDiagnostic{
+		Container: container,
+		Label:     label,
+		Reason:    fmt.Sprintf(reason, args...),
+	})
+}
+
+// byContainer returns the number of diagnostics of every container
+func (r labelReport) byContainer() map[string]int {
+	count := make(map[string]int)
+	for _, d := range r {
+		count[d.Container]++
+	}
+
+	return count
+}
+
+func (r labelReport) String() string {
+	lines := make([]string, 0, len(r))
+	for _, d := range r {
+		lines = append(lines, d.String())
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// label only parameters, handling how the jobs declared on several
+// containers are named and scheduled
+const (
+	namespaceParam   = "namespace"
+	onDuplicateParam = "on-duplicate"
+
+	namespaceNone      = "none"
+	namespaceContainer = "container"
+	namespaceProject   = "project"
+
+	onDuplicateOne    = "one"
+	onDuplicateAll    = "all"
+	onDuplicateReject = "reject"
+)
+
+// parameters of job-exec only accepted on the service container, as they
+// read files of chadburn, grant privileges to the command or run it on another
+// docker daemon
+var serviceExecParams = []string{"env-file", "privileged", "docker-host"}
+
+// job types running on a docker daemon, chosen with the docker-host parameter
+var dockerHostJobTypes = []string{jobExec, jobRun, jobServiceRun}
+
+// labels used to find the project a container belongs to
+var projectLabels = []string{
+	"com.docker.compose.project",
+	"com.docker.stack.namespace",
+}
+
+// labelJob holds the parameters of a job declared on a container, and the
+// label each parameter was read from
+type labelJob struct {
+	container string
+	project   string
+	params    map[string]interface{}
+	labels    map[string]string
+}
+
+// labelJobs holds the jobs of a given kind found on the labels, by job name
+type labelJobs struct {
+	jobType string
+	jobs    map[string][]*labelJob
+}
+
+func newLabelJobs(jobType string) *labelJobs {
+	return &labelJobs{jobType: jobType, jobs: make(map[string][]*labelJob)}
+}
+
+func (j *labelJobs) set(container, project, label, jobName, paramName, paramVal string) *labelJob {
+	var job *labelJob
+	for _, candidate := range j.jobs[jobName] {
+		if candidate.container == container {
+			job = candidate
+		}
+	}
+
+	if job == nil {
+		job = &labelJob{
+			container: container,
+			project:   project,
+			params:    map[string]interface{}{"fromDockerLabel": true},
+			labels:    make(map[string]string),
+		}
+
+		j.jobs[jobName] = append(j.jobs[jobName], job)
+	}
+
+	setJobParam(job.params, paramName, paramVal)
+	job.labels[paramName] = label
+	return job
+}
+
+// resolve names the jobs applying the `namespace` parameter, and solves the
+// collisions of jobs declared on several containers applying the
+// `on-duplicate` parameter
+func (j *labelJobs) resolve(report *labelReport) map[string]*labelJob {
+	resolved := make(map[string]*labelJob)
+
+	for _, jobName := range sortedKeys(j.jobs) {
+		jobs := j.jobs[jobName]
+		sort.Slice(jobs, func(a, b int) bool {
+			return jobs[a].container < jobs[b].container
+		})
+
+		namespace := sharedParam(jobName, jobs, namespaceParam, report)
+		onDuplicate := sharedParam(jobName, jobs, onDuplicateParam, report)
+		for _, job := range jobs {
+			delete(job.params, namespaceParam)
+			delete(job.params, onDuplicateParam)
+		}
+
+		groups := make(map[string][]*labelJob)
+		for _, job := range jobs {
+			var name string
+			switch namespace {
+			case "", namespaceNone:
+				name = jobName
+			case namespaceContainer:
+				name = job.container + "." + jobName
+			case namespaceProject:
+				name = job.project + "." + jobName
+			default:
+				report.add(job.container, job.labels[namespaceParam], "invalid value %q, expected %s, %s or %s",
+					namespace, namespaceNone, namespaceContainer, namespaceProject)
+				continue
+			}
+
+			groups[name] = append(groups[name], job)
+		}
+
+		for _, name := range sortedKeys(groups) {
+			group := groups[name]
+			if len(group) == 1 {
+				resolved[name] = group[0]
+				continue
+			}
+
+			switch onDuplicate {
+			case "", onDuplicateOne:
+				resolved[name] = group[0]
+				if onDuplicate == "" {
+					for _, job := range group[1:] {
+						report.add(job.container, "", "job %q ignored, it is also declared on container %q, "+
+							"set %s to %s, %s or %s to choose how duplicated jobs are handled",
+							name, group[0].container, onDuplicateParam, onDuplicateOne, onDuplicateAll, onDuplicateReject)
+					}
+				}
+			case onDuplicateAll:
+				for _, job := range group {
+					resolved[name+"."+job.container] = job
+				}
+			case onDuplicateReject:
+				for _, job := range group {
+					report.add(job.container, "", "job %q rejected, it is declared on %d containers", name, len(group))
+				}
+			default:
+				for _, job := range group {
+					report.add(job.container, job.labels[onDuplicateParam], "invalid value %q, expected %s, %s or %s",
+						onDuplicate, onDuplicateOne, onDuplicateAll, onDuplicateReject)
+				}
+			}
+		}
+	}
+
+	return resolved
+}
+
+// sharedParam returns the value of a parameter that applies to all the
+// containers declaring the job, jobs sorted by container name. The value of the
+// first container is used, the different values of the others are reported.
+func sharedParam(jobName string, jobs []*labelJob, param string, report *labelReport) string {
+	value, _ := jobs[0].params[param].(string)
+	for _, job := range jobs[1:] {
+		if v, _ := job.params[param].(string); v != value {
+			label := job.labels[param]
+			if label == "" {
+				label = jobs[0].labels[param]
+			}
+
+			report.add(job.container, label, "value %q of job %q ignored, container %q sets %q",
+				v, jobName, jobs[0].container, value)
+		}
+	}
+
+	return value
+}
+
+// decode decodes every job into the given map, the jobs with invalid
+// parameters, on an unknown docker host or with the name of a job of the
+// config file are left out and reported
+func (j *labelJobs) decode(result interface{}, fileJobs map[string]bool, dockerHosts map[string]*DockerHostConfig, report *labelReport) {
+	jobs := j.resolve(report)
+	for name, job := range jobs {
+		if fileJobs[j.jobType+"."+name] {
+			report.add(job.container, "", "job %q ignored, a %s with the same name is defined in the config file", name, j.jobType)
+			delete(jobs, name)
+		}
+	}
+
+	if len(jobs) == 0 {
+		return
+	}
+
+	m := reflect.ValueOf(result).Elem()
+	if m.IsNil() {
+		m.Set(reflect.MakeMap(m.Type()))
+	}
+
+	for _, jobName := range sortedKeys(jobs) {
+		if param, err := validateTemplateParams(jobs[jobName].params); err != nil {
+			report.add(jobs[jobName].container, jobs[jobName].labels[param], "rejected: %s", err)
+			continue
+		}
+
+		if host, _ := jobs[jobName].params["docker-host"].(string); host != "" && contains(dockerHostJobTypes, j.jobType) && dockerHosts[host] == nil {
+			report.add(jobs[jobName].container, jobs[jobName].labels["docker-host"], "rejected: unknown docker host %q", host)
+			continue
+		}
+
+		job := reflect.New(m.Type().Elem().Elem())
+		unused, err := weakDecode(jobs[jobName].params, job.Interface())
+		if err != nil {
+			report.add(jobs[jobName].container, "", "job %q rejected: %s", jobName, err)
+			continue
+		}
+
+		for _, param := range unused {
+			report.add(jobs[jobName].container, jobs[jobName].labels[param], "unknown parameter %q", param)
+		}
+
+		m.SetMapIndex(reflect.ValueOf(jobName), job)
+	}
+}
+
+// buildFromDockerLabels builds the configuration from the labels of the
+// containers, the labels that can not be used are returned in the report
+func (c *Config) buildFromDockerLabels(labels map[string]map[string]string) labelReport {
+	prefixes := c.labelPrefixes
+	if len(prefixes) == 0 {
+		prefixes = parseLabelPrefixes()
+	}
+
+	var report labelReport
+	execJobs := newLabelJobs(jobExec)
+	localJobs := newLabelJobs(jobLocal)
+	runJobs := newLabelJobs(jobRun)
+	serviceJobs := newLabelJobs(jobServiceRun)
+	httpJobs := newLabelJobs(jobHTTP)
 	globalConfigs := make(map[string]interface{})
+	globalOrigins := make(map[string]labelDiagnostic)
+
+	for _, c := range sortedKeys(labels) {
+		l := labels[c]
+		project := c
+		for _, label := range projectLabels {
+			if v, ok := l[label]; ok {
+				project = v
+				break
+			}
+		}
 
-	for c, l := range labels {
 		isServiceContainer := func() bool {
 			for k, v := range l {
-				if k == serviceLabel {
-					return v == "true"
+				if name, ok := prefixes.trim(k); ok && name == serviceLabelName && v == "true" {
+					return true
 				}
 			}
 			return false
 		}()
 
-		for k, v := range l {
-			parts := strings.Split(k, ".")
-			if len(parts) < 4 {
-				if isServiceContainer {
-					globalConfigs[parts[1]] = v
+		for _, k := range sortedKeys(l) {
+			v := l[k]
+			name, ok := prefixes.trim(k)
+			if !ok {
+				continue
+			}
+
+			parts := strings.Split(name, ".")
+			if len(parts) < 3 {
+				switch {
+				case parts[0] == enabledLabelName || parts[0] == serviceLabelName:
+				case isJobType(parts[0]):
+					report.add(c, k, "is incomplete, expected %s.<job-name>.<parameter>", parts[0])
+				case !isServiceContainer:
+					report.add(c, k, "ignored, global settings are only accepted on the service container")
+				default:
+					globalConfigs[parts[0]] = v
+					globalOrigins[parts[0]] = labelDiagnostic{Container: c, Label: k}
 				}
 
 				continue
 			}
 
-			jobType, jobName, jopParam := parts[1], parts[2], parts[3]
+			jobType, jobName, jopParam := parts[0], parts[1], parts[2]
 			switch {
+			case jobType == jobExec && !isServiceContainer && contains(serviceExecParams, jopParam):
+				report.add(c, k, "ignored, %s is only accepted on the service container", jopParam)
 			case jobType == jobExec: // only job exec can be provided on the non-service container
-				if _, ok := execJobs[jobName]; !ok {
-					execJobs[jobName] = make(map[string]interface{})
-					execJobs[jobName]["fromDockerLabel"] = true
-				}
-
-				setJobParam(execJobs[jobName], jopParam, v)
+				job := execJobs.set(c, project, k, jobName, jopParam, v)
 				// since this label was placed not on the service container
 				// this means we need to `exec` command in this container
 				if !isServiceContainer {
-					execJobs[jobName]["container"] = c
+					job.params["container"] = c
 				}
 			case jobType == jobLocal && isServiceContainer:
-				if _, ok := localJobs[jobName]; !ok {
-					localJobs[jobName] = make(map[string]interface{})
-					localJobs[jobName]["fromDockerLabel"] = true
-				}
-				setJobParam(localJobs[jobName], jopParam, v)
+				localJobs.set(c, project, k, jobName, jopParam, v)
 			case jobType == jobServiceRun && isServiceContainer:
-				if _, ok := serviceJobs[jobName]; !ok {
-					serviceJobs[jobName] = make(map[string]interface{})
-					serviceJobs[jobName]["fromDockerLabel"] = true
-				}
-				setJobParam(serviceJobs[jobName], jopParam, v)
+				serviceJobs.set(c, project, k, jobName, jopParam, v)
 			case jobType == jobRun && isServiceContainer:
-				if _, ok := runJobs[jobName]; !ok {
-					runJobs[jobName] = make(map[string]interface{})
-					runJobs[jobName]["fromDockerLabel"] = true
-				}
-				setJobParam(runJobs[jobName], jopParam, v)
+				runJobs.set(c, project, k, jobName, jopParam, v)
+			case jobType == jobHTTP && isServiceContainer:
+				httpJobs.set(c, project, k, jobName, jopParam, v)
+			case isJobType(jobType):
+				report.add(c, k, "ignored, %s jobs are only accepted on the service container", jobType)
 			default:
-				// TODO: warn about unknown parameter
+				report.add(c, k, "ignored, unknown job type %q", jobType)
 			}
 		}
 	}
 
-	if len(globalConfigs) > 0 {
-		if err := mapstructure.WeakDecode(globalConfigs, &c.Global); err != nil {
-			return err
+	// every setting is decoded on its own, so an invalid value is reported on
+	// the container and label it comes from
+	for _, param := range sortedKeys(globalConfigs) {
+		o := globalOrigins[param]
+		unused, err := weakDecode(map[string]interface{}{param: globalConfigs[param]}, &c.Global)
+		if err != nil {
+			report.add(o.Container, o.Label, "global setting rejected: %s", err)
+			continue
 		}
-	}
 
-	if len(execJobs) > 0 {
-		if err := mapstructure.WeakDecode(execJobs, &c.ExecJobs); err != nil {
-			return err
+		if len(unused) > 0 {
+			report.add(o.Container, o.Label, "unknown global setting %q", param)
 		}
 	}
 
-	if len(localJobs) > 0 {
-		if err := mapstructure.WeakDecode(localJobs, &c.LocalJobs); err != nil {
-			return err
+	execJobs.decode(&c.ExecJobs, c.fileJobs, c.DockerHosts, &report)
+	localJobs.decode(&c.LocalJobs, c.fileJobs, c.DockerHosts, &report)
+	serviceJobs.decode(&c.ServiceJobs, c.fileJobs, c.DockerHosts, &report)
+	runJobs.decode(&c.RunJobs, c.fileJobs, c.DockerHosts, &report)
+	httpJobs.decode(&c.HTTPJobs, c.fileJobs, c.DockerHosts, &report)
+
+	return report
+}
+
+func setJobParam(params map[string]interface{}, paramName, paramVal string) {
+	switch paramName {
+	case "volume", "mount", "environment", "label", "cap-add", "cap-drop",
+		"device", "tmpfs", "add-host", "dns", "security-opt", "constraint",
+		"secret", "config", "header", "expect-status", "script":
+		arr := []string{} // allow providing JSON arr of multi-valued params
+		if err := json.Unmarshal([]byte(paramVal), &arr); err == nil {
+			params[paramName] = arr
+			return
 		}
 	}
 
-	if len(serviceJobs) > 0 {
-		if err := mapstructure.WeakDecode(serviceJobs, &c.ServiceJobs); err != nil {
-			return err
+	params[paramName] = paramVal
+}
+
+// templateParams are the parameters rendered as templates on every execution
+// of the jobs with templating enabled
+var templateParams = []string{"command", "volume", "environment"}
+
+// validateTemplateParams checks the templates of the parameters of a job with
+// templating enabled, the name of the first invalid parameter is returned
+// along the error
+func validateTemplateParams(params map[string]interface{}) (string, error) {
+	if enabled, _ := strconv.ParseBool(fmt.Sprint(params["template"])); !enabled {
+		return "", nil
+	}
+
+	for _, param := range templateParams {
+		var values []string
+		switch v := params[param].(type) {
+		case string:
+			values = []string{v}
+		case []string:
+			values = v
+		}
+
+		for _, v := range values {
+			if err := core.ValidateTemplate(v); err != nil {
+				return param, err
+			}
 		}
 	}
 
-	if len(runJobs) > 0 {
-		if err := mapstructure.WeakDecode(runJobs, &c.RunJobs); err != nil {
-			return err
+	return "", nil
+}
+
+// weakDecode works like mapstructure.WeakDecode but also returns the keys of
+// the input that do not match any field of the output
+func weakDecode(input, output interface{}) ([]string, error) {
+	var md mapstructure.Metadata
+	decoder, err := mapstructure.NewDecoder(&mapstructure.DecoderConfig{
+		Metadata:         &md,
+		Result:           output,
+		WeaklyTypedInput: true,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := decoder.Decode(input); err != nil {
+		// flatten the multiline error returned by mapstructure
+		var decodeErr *mapstructure.Error
+		if errors.As(err, &decodeErr) {
+			return nil, errors.New(strings.Join(decodeErr.Errors, "; "))
 		}
+
+		return nil, err
 	}
 
-	return nil
+	sort.Strings(md.Unused)
+	return md.Unused, nil
 }
 
-func setJobParam(params map[string]interface{}, paramName, paramVal string) {
-	switch paramName {
-	case "volume":
-		arr := []string{} // allow providing JSON arr of volume mounts
-		if err := json.Unmarshal([]byte(paramVal), &arr); err == nil {
-			params[paramName] = arr
-			return
+func isJobType(t string) bool {
+	switch t {
+	case jobExec, jobRun, jobServiceRun, jobLocal, jobHTTP:
+		return true
+	}
+
+	return false
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+	return keys
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
 		}
 	}
 
-	params[paramName] = paramVal
+	return false
 }
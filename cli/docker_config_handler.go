@@ -2,28 +2,78 @@ package cli
 
 import (
 	"errors"
+	"fmt"
+	"net/http"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/PremoWeb/Chadburn/core"
 	docker "github.com/fsouza/go-dockerclient"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
 )
 
-var ErrNoContainerWithChadburnEnabled = errors.New("Couldn't find containers with label 'chadburn.enabled=true'")
+var ErrNoContainerWithChadburnEnabled = errors.New("Couldn't find containers with chadburn enabled")
+
+// DockerUp tells if the docker daemon of every docker host is reachable, the
+// host of the daemon of the environment is empty
+var DockerUp = promauto.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "chadburn_docker_up",
+		Help: "Whether the docker daemon is reachable (1) or not (0).",
+	},
+	[]string{"host"},
+)
+
+const (
+	// watchInterval is the interval between the checks of a healthy daemon
+	watchInterval = 10 * time.Second
+	// the checks of an unreachable daemon are retried with an exponential
+	// backoff between these delays
+	minReconnectDelay = time.Second
+	maxReconnectDelay = 30 * time.Second
+)
 
 type DockerHandler struct {
 	dockerClient *docker.Client
-	notifier     dockerLabelsUpdate
+	notifier     dockerNotifier
 	logger       core.Logger
+	prefixes     labelPrefixes
+	// host is the name of the docker host, empty for the one of the
+	// environment
+	host string
+	// discover enables the discovery of jobs in the labels
+	discover bool
+	healthy  atomic.Bool
+}
+
+type dockerNotifier interface {
+	dockerLabelsUpdate(host string, labels map[string]map[string]string)
+	// dockerHealthUpdate is called when the daemon goes down, with the
+	// error, and when it is back, with nil
+	dockerHealthUpdate(host string, err error)
+}
+
+// GetInternalDockerClient returns the client of the daemon as used by the jobs,
+// nil if there is none
+func (c *DockerHandler) GetInternalDockerClient() core.ContainerRuntime {
+	if c.dockerClient == nil {
+		return nil
+	}
+
+	return handlerClient{Client: c.dockerClient, handler: c}
 }
 
-type dockerLabelsUpdate interface {
-	dockerLabelsUpdate(map[string]map[string]string)
+// handlerClient is the client of the daemon of a handler, reporting its health
+// to the jobs, so they are skipped while it is down
+type handlerClient struct {
+	*docker.Client
+	handler *DockerHandler
 }
 
-// TODO: Implement an interface so the code does not have to use third parties directly
-func (c *DockerHandler) GetInternalDockerClient() *docker.Client {
-	return c.dockerClient
+func (c handlerClient) Healthy() bool {
+	return c.handler.Healthy()
 }
 
 func (c *DockerHandler) buildDockerClient() (*docker.Client, error) {
@@ -35,12 +85,14 @@ func (c *DockerHandler) buildDockerClient() (*docker.Client, error) {
 	return d, nil
 }
 
-func NewDockerHandler(notifier dockerLabelsUpdate, logger core.Logger) (*DockerHandler, error) {
+func NewDockerHandler(notifier dockerNotifier, prefixes labelPrefixes, logger core.Logger) (*DockerHandler, error) {
 	c := &DockerHandler{}
 	var err error
 	c.dockerClient, err = c.buildDockerClient()
 	c.notifier = notifier
+	c.prefixes = prefixes
 	c.logger = logger
+	c.discover = true
 	if err != nil {
 		return nil, err
 	}
@@ -50,56 +102,214 @@ func NewDockerHandler(notifier dockerLabelsUpdate, logger core.Logger) (*DockerH
 		return nil, err
 	}
 
+	c.setHealthy(true)
+	go c.watch()
+	return c, nil
+}
+
+// NewDockerHostHandler returns the handler of a docker host configured in a
+// `docker-host` section. The host may be unreachable at startup, its jobs
+// fail until it is up.
+func NewDockerHostHandler(name string, host *DockerHostConfig, notifier dockerNotifier, prefixes labelPrefixes, logger core.Logger) (*DockerHandler, error) {
+	client, err := host.buildClient()
+	if err != nil {
+		return nil, fmt.Errorf("error building client of docker host %q: %s", name, err)
+	}
+
+	c := &DockerHandler{
+		dockerClient: client,
+		notifier:     notifier,
+		logger:       logger,
+		prefixes:     prefixes,
+		host:         name,
+		discover:     host.Labels,
+	}
+
+	err = client.Ping()
+	if err != nil {
+		logger.Errorf("Docker host %q is down: %s", name, err)
+	}
+
+	c.setHealthy(err == nil)
+
 	go c.watch()
 	return c, nil
 }
 
+// Healthy returns true if the daemon answered the last health check
+func (c *DockerHandler) Healthy() bool {
+	return c.healthy.Load()
+}
+
 func (c *DockerHandler) watch() {
-	// Poll for changes
-	tick := time.Tick(10000 * time.Millisecond)
+	wait := watchInterval
+	reconnectDelay := minReconnectDelay
 	for {
-		select {
-		case <-tick:
-			labels, err := c.GetDockerLabels()
-			// Do not print or care if there is no container up right now
-			if err != nil && !errors.Is(err, ErrNoContainerWithChadburnEnabled) {
-				c.logger.Debugf("%v", err)
+		time.Sleep(wait)
+
+		// the jobs of an unreachable host are kept until it is back
+		if !c.checkHealth() {
+			wait = reconnectDelay
+			if reconnectDelay *= 2; reconnectDelay > maxReconnectDelay {
+				reconnectDelay = maxReconnectDelay
 			}
-			c.notifier.dockerLabelsUpdate(labels)
+			continue
+		}
+
+		wait = watchInterval
+		reconnectDelay = minReconnectDelay
+		if !c.discover {
+			continue
+		}
+
+		// Poll for changes
+		labels, err := c.GetDockerLabels()
+		// Do not print or care if there is no container up right now
+		if err != nil && !errors.Is(err, ErrNoContainerWithChadburnEnabled) {
+			c.logger.Debugf("%v", err)
 		}
+		c.notifier.dockerLabelsUpdate(c.host, labels)
+	}
+}
+
+// checkHealth pings the daemon, logging and notifying the changes of its
+// health
+func (c *DockerHandler) checkHealth() bool {
+	err := c.dockerClient.Ping()
+	healthy := err == nil
+	if c.setHealthy(healthy) == healthy {
+		return healthy
+	}
+
+	if healthy {
+		c.logger.Noticef("Docker host %s is up", c.name())
+	} else {
+		// the kept alive connections do not survive a restart of the daemon
+		c.dockerClient.HTTPClient.CloseIdleConnections()
+		c.logger.Errorf("Docker host %s is down, its jobs are skipped until it is back: %s", c.name(), err)
+	}
+
+	if c.notifier != nil {
+		c.notifier.dockerHealthUpdate(c.host, err)
+	}
+
+	return healthy
+}
+
+// setHealthy records the health of the daemon, returning the previous one
+func (c *DockerHandler) setHealthy(healthy bool) bool {
+	up := 0.0
+	if healthy {
+		up = 1
+	}
+	DockerUp.WithLabelValues(c.host).Set(up)
+
+	return c.healthy.Swap(healthy)
+}
+
+func (c *DockerHandler) name() string {
+	if c.host == "" {
+		return "of the environment"
 	}
+
+	return fmt.Sprintf("%q", c.host)
 }
 
 func (c *DockerHandler) GetDockerLabels() (map[string]map[string]string, error) {
-	conts, err := c.dockerClient.ListContainers(docker.ListContainersOptions{
+	var labels = make(map[string]map[string]string)
+
+	for _, prefix := range c.getPrefixes() {
+		conts, err := c.dockerClient.ListContainers(docker.ListContainersOptions{
+			Filters: map[string][]string{
+				"label": {prefix + "." + enabledLabelName + "=true"},
+			},
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		for _, cont := range conts {
+			if len(cont.Names) > 0 && len(cont.Labels) > 0 {
+				name := strings.TrimPrefix(cont.Names[0], "/")
+				labels[name] = c.mergeLabels(labels[name], cont.Labels)
+			}
+		}
+
+		svcLabels, err := c.getServiceLabels(prefix)
+		if err != nil {
+			return nil, err
+		}
+
+		for name, l := range svcLabels {
+			labels[name] = c.mergeLabels(labels[name], l)
+		}
+	}
+
+	if len(labels) == 0 {
+		return nil, fmt.Errorf("%w (label prefixes: %s)", ErrNoContainerWithChadburnEnabled, c.getPrefixes())
+	}
+
+	return labels, nil
+}
+
+// getServiceLabels returns the labels of the swarm services (`deploy.labels`
+// in a stack file) flagged as chadburn service. Nodes that are not swarm
+// managers can not list services, in that case no labels are returned.
+func (c *DockerHandler) getServiceLabels(prefix string) (map[string]map[string]string, error) {
+	svcs, err := c.dockerClient.ListServices(docker.ListServicesOptions{
 		Filters: map[string][]string{
-			"label": {requiredLabelFilter},
+			"label": {
+				prefix + "." + enabledLabelName + "=true",
+				prefix + "." + serviceLabelName + "=true",
+			},
 		},
 	})
 	if err != nil {
+		var dockerErr *docker.Error
+		if errors.As(err, &dockerErr) && isNotSwarmManager(dockerErr) {
+			return nil, nil
+		}
+
 		return nil, err
 	}
 
-	if len(conts) == 0 {
-		return nil, ErrNoContainerWithChadburnEnabled
+	var labels = make(map[string]map[string]string)
+
+	for _, svc := range svcs {
+		if svc.Spec.Name != "" && len(svc.Spec.Labels) > 0 {
+			labels[svc.Spec.Name] = svc.Spec.Labels
+		}
 	}
 
-	var labels = make(map[string]map[string]string)
+	return labels, nil
+}
 
-	for _, c := range conts {
-		if len(c.Names) > 0 && len(c.Labels) > 0 {
-			name := strings.TrimPrefix(c.Names[0], "/")
-			for k := range c.Labels {
-				// remove all not relevant labels
-				if !strings.HasPrefix(k, labelPrefix) {
-					delete(c.Labels, k)
-					continue
-				}
-			}
+// isNotSwarmManager reports if the error was returned by a daemon that is not
+// part of a swarm or is only a worker node
+func isNotSwarmManager(err *docker.Error) bool {
+	return err.Status == http.StatusServiceUnavailable || err.Status == http.StatusNotAcceptable
+}
+
+// mergeLabels adds to dst all the labels relevant to chadburn, including the
+// ones telling the project of the container
+func (c *DockerHandler) mergeLabels(dst, src map[string]string) map[string]string {
+	if dst == nil {
+		dst = make(map[string]string)
+	}
 
-			labels[name] = c.Labels
+	for k, v := range src {
+		if _, ok := c.getPrefixes().trim(k); ok || contains(projectLabels, k) {
+			dst[k] = v
 		}
 	}
 
-	return labels, nil
+	return dst
+}
+
+func (c *DockerHandler) getPrefixes() labelPrefixes {
+	if len(c.prefixes) == 0 {
+		return parseLabelPrefixes()
+	}
+
+	return c.prefixes
 }
@@ -0,0 +1,133 @@
+package cli
+
+import (
+	"errors"
+
+	"github.com/PremoWeb/Chadburn/core"
+	"github.com/docker/docker/api/types/swarm"
+	docker "github.com/fsouza/go-dockerclient"
+	"github.com/fsouza/go-dockerclient/testing"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	. "gopkg.in/check.v1"
+)
+
+type SuiteDockerHandler struct {
+	server *testing.DockerServer
+	client *docker.Client
+}
+
+var _ = Suite(&SuiteDockerHandler{})
+
+func (s *SuiteDockerHandler) SetUpTest(c *C) {
+	var err error
+	s.server, err = testing.NewServer("127.0.0.1:0", nil, nil)
+	c.Assert(err, IsNil)
+
+	s.client, err = docker.NewClient(s.server.URL())
+	c.Assert(err, IsNil)
+}
+
+func (s *SuiteDockerHandler) TestGetDockerLabelsFromServices(c *C) {
+	_, err := s.client.InitSwarm(docker.InitSwarmOptions{})
+	c.Assert(err, IsNil)
+
+	_, err = s.client.CreateService(docker.CreateServiceOptions{
+		ServiceSpec: swarm.ServiceSpec{
+			Annotations: swarm.Annotations{
+				Name: "stack_backup",
+				Labels: map[string]string{
+					requiredLabel: "true",
+					serviceLabel:  "true",
+					labelPrefix + "." + jobRun + ".job1.schedule": "@every 5s",
+					"com.docker.stack.namespace":                  "stack",
+				},
+			},
+			TaskTemplate: swarm.TaskSpec{
+				ContainerSpec: &swarm.ContainerSpec{Image: "test"},
+			},
+		},
+	})
+	c.Assert(err, IsNil)
+
+	h := &DockerHandler{dockerClient: s.client, logger: &TestLogger{}}
+	labels, err := h.GetDockerLabels()
+	c.Assert(err, IsNil)
+	c.Assert(labels, DeepEquals, map[string]map[string]string{
+		"stack_backup": {
+			requiredLabel: "true",
+			serviceLabel:  "true",
+			labelPrefix + "." + jobRun + ".job1.schedule": "@every 5s",
+			"com.docker.stack.namespace":                  "stack",
+		},
+	})
+}
+
+func (s *SuiteDockerHandler) TestGetDockerLabelsEmpty(c *C) {
+	h := &DockerHandler{dockerClient: s.client, logger: &TestLogger{}}
+	_, err := h.GetDockerLabels()
+	c.Assert(errors.Is(err, ErrNoContainerWithChadburnEnabled), Equals, true)
+}
+
+type healthNotifier struct {
+	errs []error
+}
+
+func (n *healthNotifier) dockerLabelsUpdate(string, map[string]map[string]string) {}
+
+func (n *healthNotifier) dockerHealthUpdate(host string, err error) {
+	n.errs = append(n.errs, err)
+}
+
+func (s *SuiteDockerHandler) TestCheckHealth(c *C) {
+	n := &healthNotifier{}
+	h := &DockerHandler{dockerClient: s.client, notifier: n, logger: &TestLogger{}, host: "db01"}
+	h.setHealthy(true)
+
+	c.Assert(h.checkHealth(), Equals, true)
+	c.Assert(n.errs, HasLen, 0)
+	c.Assert(testutil.ToFloat64(DockerUp.WithLabelValues("db01")), Equals, 1.0)
+
+	s.server.Stop()
+	c.Assert(h.checkHealth(), Equals, false)
+	c.Assert(h.Healthy(), Equals, false)
+	c.Assert(h.GetInternalDockerClient().(core.HealthReporter).Healthy(), Equals, false)
+	c.Assert(testutil.ToFloat64(DockerUp.WithLabelValues("db01")), Equals, 0.0)
+
+	// only the changes are notified
+	c.Assert(h.checkHealth(), Equals, false)
+	c.Assert(n.errs, HasLen, 1)
+	c.Assert(n.errs[0], NotNil)
+}
+
+type recordMiddleware struct {
+	executions []*core.Execution
+}
+
+func (m *recordMiddleware) ContinueOnStop() bool { return true }
+
+func (m *recordMiddleware) Run(ctx *core.Context) error {
+	err := ctx.Next()
+	ctx.Stop(err)
+	m.executions = append(m.executions, ctx.Execution)
+	return err
+}
+
+func (s *SuiteDockerHandler) TestDockerHealthUpdate(c *C) {
+	m := &recordMiddleware{}
+	config := NewConfig(&TestLogger{})
+	config.sh = core.NewScheduler(&TestLogger{})
+	config.sh.Use(m)
+
+	// notifications are disabled by default
+	config.dockerHealthUpdate("db01", errors.New("connection refused"))
+	c.Assert(m.executions, HasLen, 0)
+
+	config.Global.NotifyDockerHealth = true
+	config.dockerHealthUpdate("db01", errors.New("connection refused"))
+	config.dockerHealthUpdate("db01", nil)
+
+	c.Assert(m.executions, HasLen, 2)
+	c.Assert(m.executions[0].Failed, Equals, true)
+	c.Assert(m.executions[0].Error, ErrorMatches, "docker unavailable: connection refused")
+	c.Assert(m.executions[1].Failed, Equals, false)
+}
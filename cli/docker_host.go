@@ -0,0 +1,157 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os/exec"
+	"sync"
+	"time"
+
+	docker "github.com/fsouza/go-dockerclient"
+)
+
+// DockerHostConfig is a docker daemon, other than the one of the environment,
+// the jobs can run on
+type DockerHostConfig struct {
+	// Host is the endpoint of the daemon, e.g. `tcp://db01:2376` or
+	// `ssh://admin@db01`
+	Host string
+	// TLS certificates of tcp endpoints, as given to `docker --tlsverify`
+	TLSCACert string `gcfg:"tls-ca-cert" mapstructure:"tls-ca-cert"`
+	TLSCert   string `gcfg:"tls-cert" mapstructure:"tls-cert"`
+	TLSKey    string `gcfg:"tls-key" mapstructure:"tls-key"`
+	// Labels enables the discovery of jobs in the labels of the containers
+	// of the host
+	Labels bool `default:"false"`
+}
+
+// buildClient returns a client of the daemon, it does not connect to it
+func (c *DockerHostConfig) buildClient() (*docker.Client, error) {
+	u, err := url.Parse(c.Host)
+	if err != nil {
+		return nil, fmt.Errorf("invalid host %q: %s", c.Host, err)
+	}
+
+	switch u.Scheme {
+	case "ssh":
+		return newSSHClient(u)
+	case "tcp", "unix":
+	default:
+		return nil, fmt.Errorf("invalid host %q: unsupported scheme %q", c.Host, u.Scheme)
+	}
+
+	if c.TLSCACert != "" || c.TLSCert != "" || c.TLSKey != "" {
+		return docker.NewTLSClient(c.Host, c.TLSCert, c.TLSKey, c.TLSCACert)
+	}
+
+	return docker.NewClient(c.Host)
+}
+
+// newSSHClient returns a client connecting to the daemon through ssh, the same
+// way the docker cli does, running `docker system dial-stdio` on the remote
+// host. The ssh binary is used, so its config, agent and known hosts apply.
+func newSSHClient(u *url.URL) (*docker.Client, error) {
+	if u.Host == "" {
+		return nil, fmt.Errorf("invalid host %q: no host name", u.String())
+	}
+
+	// the address is never dialed, every connection goes through ssh
+	client, err := docker.NewClient("tcp://" + u.Hostname() + ":2375")
+	if err != nil {
+		return nil, err
+	}
+
+	dialer := &sshDialer{target: u}
+	client.Dialer = dialer
+	client.HTTPClient = &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+				return dialer.DialContext(ctx)
+			},
+			IdleConnTimeout: 30 * time.Second,
+		},
+	}
+
+	return client, nil
+}
+
+type sshDialer struct {
+	target *url.URL
+}
+
+func (d *sshDialer) Dial(network, address string) (net.Conn, error) {
+	return d.DialContext(context.Background())
+}
+
+func (d *sshDialer) DialContext(ctx context.Context) (net.Conn, error) {
+	args := []string{"-o", "ConnectTimeout=30"}
+	if d.target.Port() != "" {
+		args = append(args, "-p", d.target.Port())
+	}
+
+	host := d.target.Hostname()
+	if d.target.User != nil {
+		host = d.target.User.Username() + "@" + host
+	}
+
+	args = append(args, "--", host, "docker", "system", "dial-stdio")
+
+	// the connection outlives the context of the dial
+	cmd := exec.Command("ssh", args...)
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("error running ssh: %s", err)
+	}
+
+	return &commandConn{cmd: cmd, stdin: stdin, stdout: stdout, host: host}, nil
+}
+
+// commandConn is a net.Conn over the standard input and output of a command
+type commandConn struct {
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	stdout io.ReadCloser
+	host   string
+
+	closeOnce sync.Once
+}
+
+func (c *commandConn) Read(p []byte) (int, error)  { return c.stdout.Read(p) }
+func (c *commandConn) Write(p []byte) (int, error) { return c.stdin.Write(p) }
+
+func (c *commandConn) Close() error {
+	c.closeOnce.Do(func() {
+		c.stdin.Close()
+		c.stdout.Close()
+		c.cmd.Process.Kill()
+		c.cmd.Wait()
+	})
+
+	return nil
+}
+
+func (c *commandConn) LocalAddr() net.Addr  { return sshAddr("chadburn") }
+func (c *commandConn) RemoteAddr() net.Addr { return sshAddr(c.host) }
+
+// deadlines are not supported by pipes, the requests have their own timeouts
+func (c *commandConn) SetDeadline(t time.Time) error      { return nil }
+func (c *commandConn) SetReadDeadline(t time.Time) error  { return nil }
+func (c *commandConn) SetWriteDeadline(t time.Time) error { return nil }
+
+type sshAddr string
+
+func (a sshAddr) Network() string { return "ssh" }
+func (a sshAddr) String() string  { return string(a) }
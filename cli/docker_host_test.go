@@ -0,0 +1,211 @@
+package cli
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/PremoWeb/Chadburn/core"
+	docker "github.com/fsouza/go-dockerclient"
+	. "gopkg.in/check.v1"
+)
+
+type SuiteDockerHost struct{}
+
+var _ = Suite(&SuiteDockerHost{})
+
+func (s *SuiteDockerHost) TestBuildFromString(c *C) {
+	config, err := BuildFromString(`
+		[docker-host "db01"]
+		host = tcp://db01:2376
+		tls-ca-cert = /certs/ca.pem
+		tls-cert = /certs/cert.pem
+		tls-key = /certs/key.pem
+
+		[docker-host "web01"]
+		host = ssh://admin@web01
+		labels = true
+
+		[job-exec "vacuum"]
+		schedule = @daily
+		docker-host = db01
+		container = postgres
+		command = vacuumdb --all
+  `, &TestLogger{})
+
+	c.Assert(err, IsNil)
+	c.Assert(config.DockerHosts, DeepEquals, map[string]*DockerHostConfig{
+		"db01":  {Host: "tcp://db01:2376", TLSCACert: "/certs/ca.pem", TLSCert: "/certs/cert.pem", TLSKey: "/certs/key.pem"},
+		"web01": {Host: "ssh://admin@web01", Labels: true},
+	})
+	c.Assert(config.ExecJobs["vacuum"].DockerHost, Equals, "db01")
+}
+
+func (s *SuiteDockerHost) TestValidateDockerHosts(c *C) {
+	config, err := BuildFromString(`
+		[docker-host "db01"]
+		host = ssh://admin@db01
+
+		[job-run "backup"]
+		schedule = @daily
+		docker-host = db02
+		image = alpine
+  `, &TestLogger{})
+	c.Assert(err, IsNil)
+	c.Assert(config.validateDockerHosts(), ErrorMatches, `job-run.backup: unknown docker host "db02"`)
+
+	config.RunJobs["backup"].DockerHost = "db01"
+	c.Assert(config.validateDockerHosts(), IsNil)
+
+	config.DockerHosts["db01"].Host = "ftp://db01"
+	c.Assert(config.validateDockerHosts(), ErrorMatches, `docker host "db01": invalid host "ftp://db01": unsupported scheme "ftp"`)
+}
+
+func (s *SuiteDockerHost) TestReloadUnknownDockerHost(c *C) {
+	config := NewConfig(&TestLogger{})
+	config.sh = core.NewScheduler(&TestLogger{})
+	config.dockerHandler = &DockerHandler{}
+	config.dockerHosts = map[string]*DockerHandler{"db01": {}}
+
+	update := func(ini string) {
+		newConfig, err := BuildFromString(ini, &TestLogger{})
+		c.Assert(err, IsNil)
+		config.fileConfigUpdate(newConfig)
+	}
+
+	update(`
+		[docker-host "db01"]
+		host = ssh://admin@db01
+
+		[job-run "backup"]
+		schedule = @daily
+		docker-host = db01
+		image = alpine
+  `)
+	c.Assert(config.RunJobs, HasLen, 1)
+	c.Assert(config.RunJobs["backup"].DockerHost, Equals, "db01")
+
+	// a job on an unknown docker host rejects the whole config
+	update(`
+		[docker-host "db01"]
+		host = ssh://admin@db01
+
+		[job-run "backup"]
+		schedule = @daily
+		docker-host = db02
+		image = alpine
+  `)
+	c.Assert(config.RunJobs["backup"].DockerHost, Equals, "db01")
+
+	// as does a docker host added after startup, it is not connected
+	update(`
+		[docker-host "db01"]
+		host = ssh://admin@db01
+
+		[docker-host "db02"]
+		host = ssh://admin@db02
+
+		[job-run "backup"]
+		schedule = @daily
+		docker-host = db02
+		image = alpine
+  `)
+	c.Assert(config.RunJobs["backup"].DockerHost, Equals, "db01")
+}
+
+func (s *SuiteDockerHost) TestLabelsDockerHost(c *C) {
+	conf := Config{DockerHosts: map[string]*DockerHostConfig{"db01": {Host: "ssh://admin@db01"}}}
+	report := conf.buildFromDockerLabels(map[string]map[string]string{
+		"chadburn": {
+			requiredLabel: "true",
+			serviceLabel:  "true",
+			labelPrefix + "." + jobExec + ".vacuum.schedule":    "@daily",
+			labelPrefix + "." + jobExec + ".vacuum.container":   "postgres",
+			labelPrefix + "." + jobExec + ".vacuum.command":     "vacuumdb --all",
+			labelPrefix + "." + jobExec + ".vacuum.docker-host": "db01",
+			labelPrefix + "." + jobRun + ".backup.schedule":     "@daily",
+			labelPrefix + "." + jobRun + ".backup.image":        "postgres",
+			labelPrefix + "." + jobRun + ".backup.docker-host":  "db02",
+		},
+		"app": {
+			requiredLabel: "true",
+			labelPrefix + "." + jobExec + ".flush.schedule":    "@hourly",
+			labelPrefix + "." + jobExec + ".flush.command":     "flush",
+			labelPrefix + "." + jobExec + ".flush.docker-host": "db01",
+		},
+	})
+
+	// a container other than the service one can not run commands on
+	// another daemon
+	c.Assert(report, HasLen, 2)
+	c.Assert(report[0].Container, Equals, "app")
+	c.Assert(report[0].Label, Equals, labelPrefix+"."+jobExec+".flush.docker-host")
+	c.Assert(report[0].Reason, Equals, "ignored, docker-host is only accepted on the service container")
+	c.Assert(conf.ExecJobs["flush"].DockerHost, Equals, "")
+
+	c.Assert(report[1].Container, Equals, "chadburn")
+	c.Assert(report[1].Label, Equals, labelPrefix+"."+jobRun+".backup.docker-host")
+	c.Assert(report[1].Reason, Equals, `rejected: unknown docker host "db02"`)
+	c.Assert(conf.RunJobs, HasLen, 0)
+
+	c.Assert(conf.ExecJobs["vacuum"].DockerHost, Equals, "db01")
+}
+
+func (s *SuiteDockerHost) TestSSHClient(c *C) {
+	// a fake ssh recording its arguments and answering any request with OK
+	dir := c.MkDir()
+	script := "#!/bin/sh\n" +
+		"echo \"$@\" > " + filepath.Join(dir, "args") + "\n" +
+		"printf 'HTTP/1.1 200 OK\\r\\nContent-Length: 2\\r\\n\\r\\nOK'\n" +
+		"cat > /dev/null\n"
+	err := os.WriteFile(filepath.Join(dir, "ssh"), []byte(script), 0755)
+	c.Assert(err, IsNil)
+
+	path := os.Getenv("PATH")
+	os.Setenv("PATH", dir+string(os.PathListSeparator)+path)
+	defer os.Setenv("PATH", path)
+
+	host := &DockerHostConfig{Host: "ssh://admin@db01:2222"}
+	client, err := host.buildClient()
+	c.Assert(err, IsNil)
+	c.Assert(client.Ping(), IsNil)
+
+	args, err := os.ReadFile(filepath.Join(dir, "args"))
+	c.Assert(err, IsNil)
+	c.Assert(strings.TrimSpace(string(args)), Equals, "-o ConnectTimeout=30 -p 2222 -- admin@db01 docker system dial-stdio")
+}
+
+func (s *SuiteDockerHost) TestDockerLabelsUpdate(c *C) {
+	local, err := docker.NewClient("tcp://127.0.0.1:2375")
+	c.Assert(err, IsNil)
+	remote, err := docker.NewClient("tcp://db01:2375")
+	c.Assert(err, IsNil)
+
+	config := NewConfig(&TestLogger{})
+	config.sh = core.NewScheduler(&TestLogger{})
+	config.dockerHandler = &DockerHandler{dockerClient: local}
+	config.dockerHosts = map[string]*DockerHandler{"db01": {dockerClient: remote, host: "db01"}}
+
+	labels := map[string]map[string]string{
+		"postgres": {
+			requiredLabel: "true",
+			labelPrefix + ".job-exec.vacuum.schedule": "@daily",
+			labelPrefix + ".job-exec.vacuum.command":  "vacuumdb --all",
+		},
+	}
+
+	config.dockerLabelsUpdate("", labels)
+	config.dockerLabelsUpdate("db01", labels)
+
+	c.Assert(config.ExecJobs, HasLen, 2)
+	c.Assert(config.ExecJobs["vacuum"].DockerHost, Equals, "")
+	c.Assert(config.ExecJobs["vacuum"].Client, Equals, config.dockerHandler.GetInternalDockerClient())
+	c.Assert(config.ExecJobs["db01.vacuum"].DockerHost, Equals, "db01")
+	c.Assert(config.ExecJobs["db01.vacuum"].Client, Equals, config.dockerHosts["db01"].GetInternalDockerClient())
+	c.Assert(config.ExecJobs["db01.vacuum"].Container, Equals, "postgres")
+
+	// the jobs of a host are removed along with its labels only
+	config.dockerLabelsUpdate("db01", nil)
+	c.Assert(config.ExecJobs, HasLen, 1)
+	c.Assert(config.ExecJobs["vacuum"], NotNil)
+}
@@ -1,23 +1,82 @@
 package cli
 
 import (
+	"errors"
+	"fmt"
+
 	"github.com/PremoWeb/Chadburn/core"
 )
 
 // ValidateCommand validates the config file
 type ValidateCommand struct {
-	ConfigFile string `long:"config" description:"configuration file" default:"/etc/chadburn.conf"`
-	Logger     core.Logger
+	ConfigFile  string   `long:"config" description:"configuration file" default:"/etc/chadburn.conf"`
+	Docker      bool     `long:"docker" description:"Validate the docker labels of the running containers and services"`
+	LabelPrefix []string `long:"label-prefix" description:"Prefix of the docker labels (default: scheduler). Can be repeated or comma separated to accept several prefixes, e.g. chadburn,ofelia"`
+	Logger      core.Logger
 }
 
 // Execute runs the validation command
 func (c *ValidateCommand) Execute(args []string) error {
 	c.Logger.Debugf("Validating %q ... ", c.ConfigFile)
-	_, err := BuildFromFile(c.ConfigFile, c.Logger)
+	config, err := BuildFromFile(c.ConfigFile, c.Logger)
 	if err != nil {
 		c.Logger.Errorf("ERROR")
 		return err
 	}
+
+	if err := config.validateRegistries(); err != nil {
+		c.Logger.Errorf("ERROR")
+		return err
+	}
+
+	if err := config.validateDockerHosts(); err != nil {
+		c.Logger.Errorf("ERROR")
+		return err
+	}
+
+	if err := config.validateTemplates(); err != nil {
+		c.Logger.Errorf("ERROR")
+		return err
+	}
 	c.Logger.Debugf("OK")
+
+	if c.Docker {
+		prefixes := parseLabelPrefixes(config.Global.LabelPrefix)
+		if len(c.LabelPrefix) > 0 {
+			prefixes = parseLabelPrefixes(c.LabelPrefix...)
+		}
+
+		return c.validateDockerLabels(prefixes, config.DockerHosts)
+	}
+
 	return nil
 }
+
+func (c *ValidateCommand) validateDockerLabels(prefixes labelPrefixes, dockerHosts map[string]*DockerHostConfig) error {
+	c.Logger.Debugf("Validating docker labels with prefixes %q ... ", prefixes.String())
+
+	h := &DockerHandler{prefixes: prefixes, logger: c.Logger}
+	client, err := h.buildDockerClient()
+	if err != nil {
+		return err
+	}
+	h.dockerClient = client
+
+	labels, err := h.GetDockerLabels()
+	if err != nil && !errors.Is(err, ErrNoContainerWithChadburnEnabled) {
+		return err
+	}
+
+	config := &Config{labelPrefixes: prefixes, DockerHosts: dockerHosts}
+	report := config.buildFromDockerLabels(labels)
+	if len(report) == 0 {
+		c.Logger.Debugf("OK")
+		return nil
+	}
+
+	for _, d := range report {
+		c.Logger.Errorf("%s", d)
+	}
+
+	return fmt.Errorf("found %d invalid docker labels", len(report))
+}
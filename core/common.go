@@ -1,13 +1,17 @@
 package core
 
 import (
+	"bufio"
 	"crypto/rand"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"os"
 	"reflect"
 	"strconv"
 	"strings"
 	"time"
+	"unicode"
 
 	"github.com/armon/circbuf"
 
@@ -128,6 +132,17 @@ func (c *Context) Log(msg string) {
 	}
 }
 
+// Trigger is what fired an execution
+type Trigger string
+
+const (
+	TriggerCron   Trigger = "cron"
+	TriggerManual Trigger = "manual"
+	// TriggerDependency is reserved for the executions fired by another job,
+	// so the values seen by the scripts do not change once they exist
+	TriggerDependency Trigger = "dependency"
+)
+
 // Execution contains all the information relative to a Job execution.
 type Execution struct {
 	ID        string
@@ -138,17 +153,81 @@ type Execution struct {
 	Skipped   bool
 	Error     error
 
+	// ScheduledTime is the time the execution was due at, Attempt is the
+	// number of the attempt for that time, always 1 as executions are not
+	// retried, and Trigger is what fired the execution
+	ScheduledTime time.Time
+	Attempt       int
+	Trigger       Trigger
+
+	// LastSuccess is the start time of the last successful execution of the
+	// job, zero if none
+	LastSuccess time.Time
+
+	// Targets contains a result for every target of the job, e.g. every
+	// container matched by a selector, empty for single target jobs
+	Targets []*TargetResult
+
+	// ImageDigest is the digest of the image used by the execution, if any,
+	// PreviousImageDigest is only set when it changed since the previous one
+	ImageDigest         string
+	PreviousImageDigest string
+
 	OutputStream, ErrorStream *circbuf.Buffer `json:"-"`
 }
 
-// NewExecution returns a new Execution, with a random ID
+// TargetResult contains the outcome of a job execution on one of its targets
+type TargetResult struct {
+	Target   string
+	Duration time.Duration
+	Failed   bool
+	Error    error
+}
+
+// MarshalJSON encodes the error of the result as its message, the error
+// interface has no JSON encoding of its own
+func (r TargetResult) MarshalJSON() ([]byte, error) {
+	type result TargetResult
+
+	var msg string
+	if r.Error != nil {
+		msg = r.Error.Error()
+	}
+
+	return json.Marshal(struct {
+		result
+		Error string
+	}{result(r), msg})
+}
+
+// AddTarget records the result of the execution on the given target
+func (e *Execution) AddTarget(target string, d time.Duration, err error) {
+	e.Targets = append(e.Targets, &TargetResult{
+		Target:   target,
+		Duration: d,
+		Failed:   err != nil,
+		Error:    err,
+	})
+}
+
+// ImageChanged returns true if the image used by the execution is not the
+// same than the one of the previous execution of the job
+func (e *Execution) ImageChanged() bool {
+	return e.PreviousImageDigest != ""
+}
+
+// NewExecution returns a new Execution, with a random ID, of a job fired
+// manually right now
 func NewExecution() *Execution {
 	bufOut, _ := circbuf.NewBuffer(maxStreamSize)
 	bufErr, _ := circbuf.NewBuffer(maxStreamSize)
 	return &Execution{
-		ID:           randomID(),
-		OutputStream: bufOut,
-		ErrorStream:  bufErr,
+		ID:            randomID(),
+		ScheduledTime: time.Now(),
+		Attempt:       1,
+		Trigger:       TriggerManual,
+		OutputStream:  bufOut,
+		ErrorStream:   bufErr,
 	}
 }
 
@@ -156,20 +235,26 @@ func NewExecution() *Execution {
 func (e *Execution) Start() {
 	e.IsRunning = true
 	e.Date = time.Now()
+	trackExecution(e.ID, true)
 }
 
-// Stop stops the executions, if a ErrSkippedExecution is given the exection
-// is mark as skipped, if any other error is given the exection is mark as
-// failed. Also mark the exection as IsRunning false and save the duration time
+// Stop stops the executions, if a ErrSkippedExecution, or an error wrapping
+// it, is given the exection is mark as skipped, if any other error is given
+// the exection is mark as failed. Also mark the exection as IsRunning false and save the duration time
 func (e *Execution) Stop(err error) {
 	e.IsRunning = false
 	e.Duration = time.Since(e.Date)
+	trackExecution(e.ID, false)
 
-	if err != nil && err != ErrSkippedExecution {
+	if errors.Is(err, ErrSkippedExecution) {
+		e.Skipped = true
+		// a wrapped ErrSkippedExecution carries the reason of the skip
+		if err != ErrSkippedExecution {
+			e.Error = err
+		}
+	} else if err != nil {
 		e.Error = err
 		e.Failed = true
-	} else if err == ErrSkippedExecution {
-		e.Skipped = true
 	}
 }
 
@@ -238,6 +323,11 @@ func randomID() string {
 }
 
 func buildFindLocalImageOptions(image string) docker.ListImagesOptions {
+	// images pinned by digest are found by repository and digest
+	if repository, digest := parseImageDigest(image); digest != "" {
+		image = repository + "@" + digest
+	}
+
 	return docker.ListImagesOptions{
 		Filters: map[string][]string{
 			"reference": []string{image},
@@ -245,20 +335,36 @@ func buildFindLocalImageOptions(image string) docker.ListImagesOptions {
 	}
 }
 
-func buildPullOptions(image string) (docker.PullImageOptions, docker.AuthConfiguration) {
+func buildPullOptions(image string) (docker.PullImageOptions, docker.AuthConfiguration, error) {
 	repository, tag := docker.ParseRepositoryTag(image)
 
 	registry := parseRegistry(repository)
 
+	// the tag of an image pinned by digest is ignored, like docker does
+	if _, digest := parseImageDigest(image); digest != "" {
+		tag = digest
+	}
+
 	if tag == "" {
 		tag = "latest"
 	}
 
+	auth, err := buildAuthConfiguration(registry)
+
 	return docker.PullImageOptions{
 		Repository: repository,
 		Registry:   registry,
 		Tag:        tag,
-	}, buildAuthConfiguration(registry)
+	}, auth, err
+}
+
+// parseImageDigest splits an image pinned by digest, such as
+// `alpine:3.18@sha256:...`, in repository and digest
+func parseImageDigest(image string) (string, string) {
+	ref, digest, _ := strings.Cut(image, "@")
+	repository, _ := docker.ParseRepositoryTag(ref)
+
+	return repository, digest
 }
 
 func parseRegistry(repository string) string {
@@ -274,28 +380,92 @@ func parseRegistry(repository string) string {
 	return ""
 }
 
-func buildAuthConfiguration(registry string) docker.AuthConfiguration {
-	var auth docker.AuthConfiguration
-	if dockercfg == nil {
-		return auth
+// unknownDockerHost is the error of the jobs without client, because their
+// docker host is not configured
+func unknownDockerHost(host string) error {
+	return fmt.Errorf("error unknown docker host %q", host)
+}
+
+// buildEnvironment returns the variables read from envFile, if any, merged
+// with env, which take precedence. Variables are in `KEY=value` form.
+func buildEnvironment(envFile string, env []string) ([]string, error) {
+	var vars []string
+	if envFile != "" {
+		var err error
+		if vars, err = parseEnvFile(envFile); err != nil {
+			return nil, err
+		}
 	}
 
-	if v, ok := dockercfg.Configs[registry]; ok {
-		return v
+	return mergeEnvironment(vars, env), nil
+}
+
+// executionEnvironment returns the variables describing the execution to the
+// command of the job, they take precedence over the ones of the job
+func executionEnvironment(j Job, e *Execution) []string {
+	return []string{
+		"CHADBURN_JOB_NAME=" + j.GetName(),
+		"CHADBURN_EXECUTION_ID=" + e.ID,
+		"CHADBURN_SCHEDULED_TIME=" + e.ScheduledTime.Format(time.RFC3339),
+		"CHADBURN_ATTEMPT=" + strconv.Itoa(e.Attempt),
+		"CHADBURN_TRIGGER=" + string(e.Trigger),
+	}
+}
+
+// parseEnvFile reads a file in the format of `docker run --env-file`: one
+// `KEY=value` per line, blank lines and lines starting with # are ignored and
+// a bare `KEY` takes its value from the environment of chadburn, if set
+func parseEnvFile(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading env file: %s", err)
 	}
+	defer f.Close()
 
-	// try to fetch configs from docker hub default registry urls
-	// see example here: https://www.projectatomic.io/blog/2016/03/docker-credentials-store/
-	if registry == "" {
-		if v, ok := dockercfg.Configs["https://index.docker.io/v2/"]; ok {
-			return v
+	var vars []string
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimLeftFunc(scanner.Text(), unicode.IsSpace)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
 		}
-		if v, ok := dockercfg.Configs["https://index.docker.io/v1/"]; ok {
-			return v
+
+		key, _, hasValue := strings.Cut(line, "=")
+		if key == "" || strings.ContainsAny(key, " \t") {
+			return nil, fmt.Errorf("invalid variable %q at %s:%d", key, path, n)
+		}
+
+		if hasValue {
+			vars = append(vars, line)
+		} else if value, ok := os.LookupEnv(key); ok {
+			vars = append(vars, key+"="+value)
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading env file: %s", err)
+	}
+
+	return vars, nil
+}
+
+// mergeEnvironment appends the variables of override to base, replacing the
+// ones with the same key in place
+func mergeEnvironment(base, override []string) []string {
+	merged := make([]string, 0, len(base)+len(override))
+	index := make(map[string]int)
+	for _, v := range append(append([]string{}, base...), override...) {
+		key, _, _ := strings.Cut(v, "=")
+		if i, ok := index[key]; ok {
+			merged[i] = v
+			continue
 		}
+
+		index[key] = len(merged)
+		merged = append(merged, v)
 	}
 
-	return auth
+	return merged
 }
 
 const HashmeTagName = "hash"
@@ -319,6 +489,10 @@ func getHash(t reflect.Type, v reflect.Value, hash *string) {
 				*hash += strconv.FormatInt(fieldv.Int(), 10)
 			} else if kind == reflect.Bool {
 				*hash += strconv.FormatBool(fieldv.Bool())
+			} else if kind == reflect.Slice && field.Type.Elem().Kind() == reflect.String {
+				for i := 0; i < fieldv.Len(); i++ {
+					*hash += fieldv.Index(i).String() + "\x00"
+				}
 			} else {
 				panic("Unsupported field type")
 			}
@@ -3,6 +3,8 @@ package core
 import (
 	"errors"
 	"fmt"
+	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
@@ -319,9 +321,76 @@ func (*TestLogger) Errorf(format string, args ...interface{})    {}
 func (*TestLogger) Noticef(format string, args ...interface{})   {}
 func (*TestLogger) Warningf(format string, args ...interface{})  {}
 
+func (s *SuiteCommon) TestExecutionEnvironment(c *C) {
+	job := &TestJob{}
+	job.Name = "backup"
+
+	e := NewExecution()
+	c.Assert(e.Attempt, Equals, 1)
+	c.Assert(e.Trigger, Equals, TriggerManual)
+
+	e.ScheduledTime = time.Date(2023, 5, 1, 3, 0, 0, 0, time.UTC)
+	e.Trigger = TriggerCron
+	c.Assert(executionEnvironment(job, e), DeepEquals, []string{
+		"CHADBURN_JOB_NAME=backup",
+		"CHADBURN_EXECUTION_ID=" + e.ID,
+		"CHADBURN_SCHEDULED_TIME=2023-05-01T03:00:00Z",
+		"CHADBURN_ATTEMPT=1",
+		"CHADBURN_TRIGGER=cron",
+	})
+}
+
+// assertExecutionVars checks the attempt and trigger variables of an execution
+// fired manually, as the ones of the tests, are in the environment
+func assertExecutionVars(c *C, env []string) {
+	vars := make(map[string]bool)
+	for _, v := range env {
+		vars[v] = true
+	}
+
+	c.Assert(vars["CHADBURN_ATTEMPT=1"], Equals, true, Commentf("%v", env))
+	c.Assert(vars["CHADBURN_TRIGGER=manual"], Equals, true, Commentf("%v", env))
+}
+
 func (s *SuiteCommon) TestParseRegistry(c *C) {
 	c.Assert(parseRegistry("example.com:port/dir/image"), Equals, "example.com:port")
 	c.Assert(parseRegistry("example.com:port/image"), Equals, "example.com:port")
 	c.Assert(parseRegistry("dir/image"), Equals, "")
 	c.Assert(parseRegistry("image"), Equals, "")
 }
+
+func (s *SuiteCommon) TestBuildEnvironment(c *C) {
+	os.Setenv("CHADBURN_TEST_INHERITED", "from-env")
+	defer os.Unsetenv("CHADBURN_TEST_INHERITED")
+
+	path := filepath.Join(c.MkDir(), "test.env")
+	err := os.WriteFile(path, []byte(`# comment
+FOO=file
+  BAR=bar baz
+
+CHADBURN_TEST_INHERITED
+CHADBURN_TEST_UNSET
+`), 0600)
+	c.Assert(err, IsNil)
+
+	env, err := buildEnvironment(path, []string{"FOO=explicit", "QUX=qux"})
+	c.Assert(err, IsNil)
+	c.Assert(env, DeepEquals, []string{
+		"FOO=explicit",
+		"BAR=bar baz",
+		"CHADBURN_TEST_INHERITED=from-env",
+		"QUX=qux",
+	})
+
+	env, err = buildEnvironment("", nil)
+	c.Assert(err, IsNil)
+	c.Assert(env, HasLen, 0)
+
+	_, err = buildEnvironment(filepath.Join(c.MkDir(), "missing.env"), nil)
+	c.Assert(err, ErrorMatches, "error reading env file: .*")
+
+	err = os.WriteFile(path, []byte("INVALID KEY=foo\n"), 0600)
+	c.Assert(err, IsNil)
+	_, err = buildEnvironment(path, nil)
+	c.Assert(err, ErrorMatches, `invalid variable "INVALID KEY" at .*test.env:1`)
+}
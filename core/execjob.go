@@ -1,32 +1,121 @@
 package core
 
 import (
+	"errors"
 	"fmt"
+	"math/rand"
 	"reflect"
+	"sort"
+	"strings"
+	"time"
 
 	docker "github.com/fsouza/go-dockerclient"
 	"github.com/gobs/args"
 )
 
+// Modes to pick the containers matching a container selector
+const (
+	SelectorModeAll          = "all"
+	SelectorModeRandom       = "random"
+	SelectorModeFirstHealthy = "first-healthy"
+)
+
+// What to do when the target container is not running, or not healthy
+const (
+	OnContainerDownFail = "fail"
+	OnContainerDownSkip = "skip"
+	OnContainerDownWait = "wait"
+)
+
+var ErrNoContainerMatched = errors.New("no running container matches the selector")
+
+const containerWaitInterval = time.Second
+
 type ExecJob struct {
 	BareJob   `mapstructure:",squash"`
-	Client    *docker.Client `json:"-"`
-	Container string         `hash:"true"`
-	User      string         `default:"root" hash:"true"`
-	TTY       bool           `default:"false" hash:"true"`
+	Client    ContainerRuntime `json:"-"`
+	Container string           `hash:"true"`
+	// DockerHost is the name of a `docker-host` section, the daemon of the
+	// environment is used if empty
+	DockerHost string `gcfg:"docker-host" mapstructure:"docker-host" hash:"true"`
+	// ContainerSelector selects the target containers by label instead of
+	// name, e.g. `com.docker.compose.service=php`
+	ContainerSelector string `gcfg:"container-selector" mapstructure:"container-selector" hash:"true"`
+	SelectorMode      string `gcfg:"selector-mode" mapstructure:"selector-mode" default:"all" hash:"true"`
+	// RequireHealthy only runs the command if the container healthcheck
+	// reports healthy, containers without healthcheck are always healthy
+	RequireHealthy  bool   `gcfg:"require-healthy" mapstructure:"require-healthy" default:"false" hash:"true"`
+	OnContainerDown string `gcfg:"on-container-down" mapstructure:"on-container-down" default:"fail" hash:"true"`
+	WaitTimeout     string `gcfg:"wait-timeout" mapstructure:"wait-timeout" default:"5m" hash:"true"`
+	User            string `default:"root" hash:"true"`
+	TTY             bool   `default:"false" hash:"true"`
+	// Template renders the command and the environment variables as
+	// templates on every execution, see TemplateData
+	Template bool `default:"false" hash:"true"`
+	// Environment variables in `KEY=value` form, they take precedence over
+	// the ones read from EnvFile, which is read on every execution
+	Environment []string `hash:"true"`
+	EnvFile     string   `gcfg:"env-file" mapstructure:"env-file" hash:"true"`
+	WorkingDir  string   `gcfg:"workdir" mapstructure:"workdir" hash:"true"`
+	Privileged  bool     `default:"false" hash:"true"`
+	// Script is run by Shell instead of the command, fed through the standard
+	// input of the exec, every value is a line
+	Script []string `hash:"true"`
+	Shell  string   `default:"/bin/sh" hash:"true"`
 }
 
-func NewExecJob(c *docker.Client) *ExecJob {
+func NewExecJob(c ContainerRuntime) *ExecJob {
 	return &ExecJob{Client: c}
 }
 
-func (j *ExecJob) Run(ctx *Context) error {
-	exec, err := j.buildExec()
+func (j *ExecJob) Run(ctx *Context) (err error) {
+	if j.Client == nil {
+		return unknownDockerHost(j.DockerHost)
+	}
+
+	if err := checkDocker(j.Client); err != nil {
+		return err
+	}
+
+	defer func() { err = dockerError(j.Client, err) }()
+
+	if j.ContainerSelector == "" {
+		return j.runIn(ctx.Execution, j.Container)
+	}
+
+	containers, err := j.selectContainers()
+	if err != nil {
+		return err
+	}
+
+	var failed int
+	for _, container := range containers {
+		start := time.Now()
+		err := j.runIn(ctx.Execution, container)
+		ctx.Execution.AddTarget(container, time.Since(start), err)
+		if err != nil {
+			failed++
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("error running on %d of %d containers", failed, len(containers))
+	}
+
+	return nil
+}
+
+func (j *ExecJob) runIn(e *Execution, container string) error {
+	if err := j.checkContainer(container); err != nil {
+		return err
+	}
+
+	exec, err := j.buildExec(e, container)
 	if err != nil {
 		return err
 	}
 
-	if err := j.startExec(ctx.Execution, exec); err != nil {
+	if err := j.startExec(e, exec); err != nil {
 		return err
 	}
 
@@ -40,15 +129,177 @@ func (j *ExecJob) Hash() string {
 	return hash
 }
 
-func (j *ExecJob) buildExec() (*docker.Exec, error) {
+// checkContainer verifies the container is running, and healthy if required,
+// before creating the exec. Depending on OnContainerDown a down container
+// fails or skips the execution, or is waited for up to WaitTimeout.
+func (j *ExecJob) checkContainer(name string) error {
+	var timeout time.Duration
+	if j.OnContainerDown == OnContainerDownWait {
+		var err error
+		if timeout, err = time.ParseDuration(j.WaitTimeout); err != nil {
+			return fmt.Errorf("invalid wait timeout %q: %s", j.WaitTimeout, err)
+		}
+	}
+
+	deadline := time.Now().Add(timeout)
+	for {
+		c, err := j.Client.InspectContainer(name)
+		if err != nil {
+			return fmt.Errorf("error inspecting container %q: %s", name, err)
+		}
+
+		reason := containerDownReason(c, j.RequireHealthy)
+		if reason == "" {
+			return nil
+		}
+
+		switch j.OnContainerDown {
+		case "", OnContainerDownFail:
+			return fmt.Errorf("container %q %s", name, reason)
+		case OnContainerDownSkip:
+			return fmt.Errorf("%w: container %q %s", ErrSkippedExecution, name, reason)
+		case OnContainerDownWait:
+			if time.Now().After(deadline) {
+				return fmt.Errorf("container %q %s after waiting %s", name, reason, timeout)
+			}
+
+			time.Sleep(containerWaitInterval)
+		default:
+			return fmt.Errorf("invalid on-container-down value %q", j.OnContainerDown)
+		}
+	}
+}
+
+// containerDownReason returns why the container can not run commands, or an
+// empty string if it can
+func containerDownReason(c *docker.Container, requireHealthy bool) string {
+	switch {
+	case c.State.Restarting:
+		return "is restarting"
+	case c.State.Paused:
+		return "is paused"
+	case !c.State.Running:
+		return fmt.Sprintf("is not running (status %s)", c.State.StateString())
+	case requireHealthy && c.State.Health.Status != "" && c.State.Health.Status != "healthy":
+		return fmt.Sprintf("is not healthy (health %s)", c.State.Health.Status)
+	}
+
+	return ""
+}
+
+// selectContainers returns the names of the running containers matching the
+// selector, filtered according to the selector mode
+func (j *ExecJob) selectContainers() ([]string, error) {
+	if j.Container != "" {
+		return nil, fmt.Errorf("container and container-selector can not be used at the same time")
+	}
+
+	filters := map[string][]string{
+		"label":  parseContainerSelector(j.ContainerSelector),
+		"status": {"running"},
+	}
+
+	switch j.SelectorMode {
+	case "", SelectorModeAll, SelectorModeRandom, SelectorModeFirstHealthy:
+	default:
+		return nil, fmt.Errorf("invalid selector mode %q", j.SelectorMode)
+	}
+
+	conts, err := j.Client.ListContainers(docker.ListContainersOptions{Filters: filters})
+	if err != nil {
+		return nil, fmt.Errorf("error listing containers: %s", err)
+	}
+
+	var names []string
+	for _, c := range conts {
+		if len(c.Names) > 0 {
+			names = append(names, strings.TrimPrefix(c.Names[0], "/"))
+		}
+	}
+
+	if len(names) == 0 {
+		return nil, fmt.Errorf("%w %q", ErrNoContainerMatched, j.ContainerSelector)
+	}
+
+	sort.Strings(names)
+	switch j.SelectorMode {
+	case SelectorModeRandom:
+		return []string{names[rand.Intn(len(names))]}, nil
+	case SelectorModeFirstHealthy:
+		return j.firstHealthy(names)
+	}
+
+	return names, nil
+}
+
+// firstHealthy returns the first of the containers that is healthy, the
+// containers without healthcheck are healthy as long as they run
+func (j *ExecJob) firstHealthy(names []string) ([]string, error) {
+	for _, name := range names {
+		c, err := j.Client.InspectContainer(name)
+		if err != nil {
+			return nil, fmt.Errorf("error inspecting container %q: %s", name, err)
+		}
+
+		if containerDownReason(c, true) == "" {
+			return []string{name}, nil
+		}
+	}
+
+	return nil, fmt.Errorf("%w %q, none of them is healthy", ErrNoContainerMatched, j.ContainerSelector)
+}
+
+// parseContainerSelector returns the docker label filters of a selector, the
+// selector is a comma separated list of `key` or `key=value` conditions that
+// all must be met
+func parseContainerSelector(selector string) []string {
+	var filters []string
+	for _, cond := range strings.Split(selector, ",") {
+		if cond = strings.TrimSpace(cond); cond != "" {
+			filters = append(filters, cond)
+		}
+	}
+
+	return filters
+}
+
+func (j *ExecJob) buildExec(e *Execution, container string) (*docker.Exec, error) {
+	data := newTemplateData(j, e, j.Template)
+	environment, err := renderTemplates(j.Environment, data)
+	if err != nil {
+		return nil, err
+	}
+
+	env, err := buildEnvironment(j.EnvFile, environment)
+	if err != nil {
+		return nil, err
+	}
+
+	env = mergeEnvironment(env, executionEnvironment(j, e))
+
+	command, err := renderTemplate(j.Command, data)
+	if err != nil {
+		return nil, err
+	}
+
+	cmd := args.GetArgs(command)
+	if len(j.Script) > 0 {
+		if cmd, err = scriptShell(j.Command, j.Shell); err != nil {
+			return nil, err
+		}
+	}
+
 	exec, err := j.Client.CreateExec(docker.CreateExecOptions{
-		AttachStdin:  false,
+		AttachStdin:  len(j.Script) > 0,
 		AttachStdout: true,
 		AttachStderr: true,
 		Tty:          j.TTY,
-		Cmd:          args.GetArgs(j.Command),
-		Container:    j.Container,
+		Cmd:          cmd,
+		Container:    container,
 		User:         j.User,
+		Env:          env,
+		WorkingDir:   j.WorkingDir,
+		Privileged:   j.Privileged,
 	})
 
 	if err != nil {
@@ -59,12 +310,18 @@ func (j *ExecJob) buildExec() (*docker.Exec, error) {
 }
 
 func (j *ExecJob) startExec(e *Execution, exec *docker.Exec) error {
-	err := j.Client.StartExec(exec.ID, docker.StartExecOptions{
+	opts := docker.StartExecOptions{
 		Tty:          j.TTY,
 		OutputStream: e.OutputStream,
 		ErrorStream:  e.ErrorStream,
 		RawTerminal:  j.TTY,
-	})
+	}
+
+	if len(j.Script) > 0 {
+		opts.InputStream = strings.NewReader(joinScript(j.Script))
+	}
+
+	err := j.Client.StartExec(exec.ID, opts)
 
 	if err != nil {
 		return fmt.Errorf("error starting exec: %s", err)
@@ -3,6 +3,12 @@ package core
 import (
 	"archive/tar"
 	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
 
 	"github.com/fsouza/go-dockerclient"
 	"github.com/fsouza/go-dockerclient/testing"
@@ -23,6 +29,11 @@ func (s *SuiteExecJob) SetUpTest(c *C) {
 	s.server, err = testing.NewServer("127.0.0.1:0", nil, nil)
 	c.Assert(err, IsNil)
 
+	// the fake server reports an API version too old for exec env and workdir
+	s.server.CustomHandler("/version", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{"ApiVersion": "1.41"})
+	}))
+
 	s.client, err = docker.NewClient(s.server.URL())
 	c.Assert(err, IsNil)
 
@@ -58,6 +69,171 @@ func (s *SuiteExecJob) TestRun(c *C) {
 	c.Assert(exec.ProcessConfig.Tty, Equals, true)
 }
 
+func (s *SuiteExecJob) TestRunSelector(c *C) {
+	s.createLabeledContainer(c, "proj-php-1", "php")
+	s.createLabeledContainer(c, "proj-php-2", "php")
+	s.createLabeledContainer(c, "proj-nginx-1", "nginx")
+
+	job := &ExecJob{Client: s.client}
+	job.ContainerSelector = "com.docker.compose.service=php"
+	job.Command = `echo -a "foo bar"`
+
+	e := NewExecution()
+	err := job.Run(&Context{Execution: e})
+	c.Assert(err, IsNil)
+	c.Assert(e.Targets, HasLen, 2)
+	c.Assert(e.Targets[0].Target, Equals, "proj-php-1")
+	c.Assert(e.Targets[0].Failed, Equals, false)
+	c.Assert(e.Targets[1].Target, Equals, "proj-php-2")
+
+	for name, execs := range map[string]int{"proj-php-1": 1, "proj-php-2": 1, "proj-nginx-1": 0} {
+		container, err := s.client.InspectContainer(name)
+		c.Assert(err, IsNil)
+		c.Assert(container.ExecIDs, HasLen, execs)
+	}
+
+	job.SelectorMode = SelectorModeRandom
+	e = NewExecution()
+	err = job.Run(&Context{Execution: e})
+	c.Assert(err, IsNil)
+	c.Assert(e.Targets, HasLen, 1)
+}
+
+func (s *SuiteExecJob) TestRunSelectorFirstHealthy(c *C) {
+	runtime := &fakeRuntime{containers: map[string]string{
+		"proj-php-1": "unhealthy",
+		"proj-php-2": "",
+		"proj-php-3": "healthy",
+	}}
+
+	job := NewExecJob(runtime)
+	job.ContainerSelector = "com.docker.compose.service=php"
+	job.SelectorMode = SelectorModeFirstHealthy
+	job.Command = "php artisan cache:clear"
+
+	// the container without healthcheck counts as healthy
+	e := NewExecution()
+	c.Assert(job.Run(&Context{Job: job, Execution: e}), IsNil)
+	c.Assert(e.Targets, HasLen, 1)
+	c.Assert(e.Targets[0].Target, Equals, "proj-php-2")
+
+	runtime.containers["proj-php-2"] = "starting"
+	e = NewExecution()
+	c.Assert(job.Run(&Context{Job: job, Execution: e}), IsNil)
+	c.Assert(e.Targets[0].Target, Equals, "proj-php-3")
+
+	runtime.containers["proj-php-3"] = "unhealthy"
+	err := job.Run(&Context{Job: job, Execution: NewExecution()})
+	c.Assert(errors.Is(err, ErrNoContainerMatched), Equals, true)
+	c.Assert(err, ErrorMatches, `.*none of them is healthy`)
+}
+
+func (s *SuiteExecJob) TestRunSelectorNoMatch(c *C) {
+	job := &ExecJob{Client: s.client}
+	job.ContainerSelector = "com.docker.compose.service=php"
+	job.Command = `echo -a "foo bar"`
+
+	err := job.Run(&Context{Execution: NewExecution()})
+	c.Assert(errors.Is(err, ErrNoContainerMatched), Equals, true)
+}
+
+func (s *SuiteExecJob) TestRunContainerDown(c *C) {
+	err := s.client.StopContainer(ContainerFixture, 0)
+	c.Assert(err, IsNil)
+
+	job := &ExecJob{Client: s.client}
+	job.Container = ContainerFixture
+	job.Command = `echo -a "foo bar"`
+
+	err = job.Run(&Context{Execution: NewExecution()})
+	c.Assert(err, ErrorMatches, `container "test-container" is not running \(status exited\)`)
+
+	job.OnContainerDown = OnContainerDownSkip
+	e := NewExecution()
+	e.Start()
+	e.Stop(job.Run(&Context{Execution: e}))
+	c.Assert(e.Skipped, Equals, true)
+	c.Assert(e.Failed, Equals, false)
+	c.Assert(e.Error, ErrorMatches, `skipped execution: container "test-container" is not running \(status exited\)`)
+
+	job.OnContainerDown = OnContainerDownWait
+	job.WaitTimeout = "1ms"
+	err = job.Run(&Context{Execution: NewExecution()})
+	c.Assert(err, ErrorMatches, `container "test-container" is not running \(status exited\) after waiting 1ms`)
+
+	container, err := s.client.InspectContainer(ContainerFixture)
+	c.Assert(err, IsNil)
+	c.Assert(container.ExecIDs, HasLen, 0)
+}
+
+func (s *SuiteExecJob) TestRunEnvironment(c *C) {
+	var opts docker.CreateExecOptions
+	s.server.CustomHandler("/containers/.*/exec", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		json.Unmarshal(body, &opts)
+
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		s.server.DefaultHandler().ServeHTTP(w, r)
+	}))
+
+	envFile := filepath.Join(c.MkDir(), "job.env")
+	err := os.WriteFile(envFile, []byte("FOO=file\nBAR=bar\n"), 0600)
+	c.Assert(err, IsNil)
+
+	client, err := docker.NewClient(s.server.URL())
+	c.Assert(err, IsNil)
+
+	job := &ExecJob{Client: client}
+	job.Container = ContainerFixture
+	job.Command = "./cleanup.sh"
+	job.Environment = []string{"FOO=explicit"}
+	job.EnvFile = envFile
+	job.WorkingDir = "/app"
+	job.Privileged = true
+
+	e := NewExecution()
+	err = job.Run(&Context{Execution: e})
+	c.Assert(err, IsNil)
+	c.Assert(opts.Env, DeepEquals, append([]string{"FOO=explicit", "BAR=bar"}, executionEnvironment(job, e)...))
+	assertExecutionVars(c, opts.Env)
+	c.Assert(opts.WorkingDir, Equals, "/app")
+	c.Assert(opts.Privileged, Equals, true)
+}
+
+func (s *SuiteExecJob) TestRunScript(c *C) {
+	runtime := &fakeRuntime{output: "done\n"}
+
+	job := NewExecJob(runtime)
+	job.Container = ContainerFixture
+	job.Script = []string{"cd /var/www", "php artisan cache:clear | tee -a /var/log/cache.log"}
+	job.Shell = "/bin/bash -e"
+
+	e := NewExecution()
+	c.Assert(job.Run(&Context{Execution: e}), IsNil)
+	c.Assert(runtime.exec.Cmd, DeepEquals, []string{"/bin/bash", "-e"})
+	c.Assert(runtime.exec.AttachStdin, Equals, true)
+	c.Assert(runtime.input, Equals, "cd /var/www\nphp artisan cache:clear | tee -a /var/log/cache.log\n")
+	c.Assert(e.OutputStream.String(), Equals, "done\n")
+
+	job.Command = "ls"
+	err := job.Run(&Context{Execution: NewExecution()})
+	c.Assert(err, ErrorMatches, "command and script can not be used together")
+}
+
+func (s *SuiteExecJob) createLabeledContainer(c *C, name, service string) {
+	cont, err := s.client.CreateContainer(docker.CreateContainerOptions{
+		Name: name,
+		Config: &docker.Config{
+			Image:  "test",
+			Labels: map[string]string{"com.docker.compose.service": service},
+		},
+	})
+	c.Assert(err, IsNil)
+
+	err = s.client.StartContainer(cont.ID, nil)
+	c.Assert(err, IsNil)
+}
+
 func (s *SuiteExecJob) buildContainer(c *C) {
 	inputbuf := bytes.NewBuffer(nil)
 	tr := tar.NewWriter(inputbuf)
@@ -72,9 +248,13 @@ func (s *SuiteExecJob) buildContainer(c *C) {
 	})
 	c.Assert(err, IsNil)
 
-	_, err = s.client.CreateContainer(docker.CreateContainerOptions{
+	cont, err := s.client.CreateContainer(docker.CreateContainerOptions{
 		Name:   ContainerFixture,
 		Config: &docker.Config{Image: "test"},
 	})
+	c.Assert(err, IsNil)
+
+	err = s.client.StartContainer(cont.ID, nil)
+	c.Assert(err, IsNil)
 
 }
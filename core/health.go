@@ -0,0 +1,42 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrDockerUnavailable is returned by the docker jobs while their docker daemon
+// can not be reached, their executions are marked as skipped
+var ErrDockerUnavailable = fmt.Errorf("%w: docker unavailable", ErrSkippedExecution)
+
+// HealthReporter is implemented by the runtimes which know if their daemon can
+// be reached, e.g. the clients of the docker daemons watched by chadburn. The
+// executions of the jobs using a runtime reported unhealthy are skipped.
+type HealthReporter interface {
+	Healthy() bool
+}
+
+// checkDocker returns ErrDockerUnavailable if the daemon of the client is
+// known to be unreachable
+func checkDocker(c ContainerRuntime) error {
+	if h, ok := c.(HealthReporter); ok && !h.Healthy() {
+		return ErrDockerUnavailable
+	}
+
+	return nil
+}
+
+// dockerError returns ErrDockerUnavailable instead of the error of a failed
+// execution if the daemon can not be reached anymore, e.g. it was restarted
+// while the job was running
+func dockerError(c ContainerRuntime, err error) error {
+	if err == nil || errors.Is(err, ErrSkippedExecution) {
+		return err
+	}
+
+	if c.Ping() != nil {
+		return ErrDockerUnavailable
+	}
+
+	return err
+}
@@ -0,0 +1,68 @@
+package core
+
+import (
+	"errors"
+
+	docker "github.com/fsouza/go-dockerclient"
+	"github.com/fsouza/go-dockerclient/testing"
+	. "gopkg.in/check.v1"
+)
+
+type SuiteHealth struct {
+	server *testing.DockerServer
+	client *docker.Client
+}
+
+var _ = Suite(&SuiteHealth{})
+
+func (s *SuiteHealth) SetUpTest(c *C) {
+	var err error
+	s.server, err = testing.NewServer("127.0.0.1:0", nil, nil)
+	c.Assert(err, IsNil)
+
+	s.client, err = docker.NewClient(s.server.URL())
+	c.Assert(err, IsNil)
+}
+
+// healthRuntime is a runtime reporting the health of its daemon
+type healthRuntime struct {
+	ContainerRuntime
+	healthy bool
+}
+
+func (r *healthRuntime) Healthy() bool {
+	return r.healthy
+}
+
+func (s *SuiteHealth) TestRunSkippedWhileUnavailable(c *C) {
+	runtime := &healthRuntime{ContainerRuntime: s.client}
+
+	job := &RunJob{Client: runtime}
+	job.Image = "busybox"
+
+	e := NewExecution()
+	e.Start()
+	err := job.Run(&Context{Execution: e})
+	c.Assert(err, Equals, ErrDockerUnavailable)
+
+	e.Stop(err)
+	c.Assert(e.Skipped, Equals, true)
+	c.Assert(e.Failed, Equals, false)
+	c.Assert(e.Error, ErrorMatches, "skipped execution: docker unavailable")
+
+	runtime.healthy = true
+	c.Assert(checkDocker(runtime), IsNil)
+
+	// the runtimes without health are always tried
+	c.Assert(checkDocker(s.client), IsNil)
+}
+
+func (s *SuiteHealth) TestDockerError(c *C) {
+	failure := errors.New("error creating container")
+	c.Assert(dockerError(s.client, nil), IsNil)
+	c.Assert(dockerError(s.client, failure), Equals, failure)
+
+	// the daemon went away during the execution
+	s.server.Stop()
+	c.Assert(dockerError(s.client, failure), Equals, ErrDockerUnavailable)
+}
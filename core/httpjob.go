@@ -0,0 +1,174 @@
+package core
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// HTTPJob calls an endpoint, the response body is the output of the execution
+type HTTPJob struct {
+	BareJob `mapstructure:",squash"`
+	Method  string `default:"GET" hash:"true"`
+	URL     string `hash:"true"`
+	// Header holds headers in `Name: value` form
+	Header   []string `hash:"true"`
+	Body     string   `hash:"true"`
+	BodyFile string   `gcfg:"body-file" mapstructure:"body-file" hash:"true"`
+	Timeout  string   `default:"30s" hash:"true"`
+	// ExpectStatus holds the accepted status codes, e.g. `204` or `3xx`, any
+	// 2xx status is accepted if empty
+	ExpectStatus []string `gcfg:"expect-status" mapstructure:"expect-status" hash:"true"`
+	// ExpectBody is a regular expression the response body has to match
+	ExpectBody string `gcfg:"expect-body" mapstructure:"expect-body" hash:"true"`
+}
+
+func NewHTTPJob() *HTTPJob {
+	return &HTTPJob{}
+}
+
+// GetCommand returns the method and the URL, as shown by the middlewares
+func (j *HTTPJob) GetCommand() string {
+	return j.Method + " " + j.URL
+}
+
+func (j *HTTPJob) Run(ctx *Context) error {
+	req, err := j.buildRequest()
+	if err != nil {
+		return err
+	}
+
+	timeout, err := time.ParseDuration(j.Timeout)
+	if err != nil {
+		return fmt.Errorf("invalid timeout %q: %s", j.Timeout, err)
+	}
+
+	client := &http.Client{Timeout: timeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("error calling %s: %s", j.URL, err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxStreamSize))
+	if err != nil {
+		return fmt.Errorf("error reading response: %s", err)
+	}
+
+	ctx.Execution.OutputStream.Write(body)
+
+	ok, err := j.expectedStatus(resp.StatusCode)
+	if err != nil {
+		return err
+	}
+
+	if !ok {
+		return fmt.Errorf("error unexpected status code: %d", resp.StatusCode)
+	}
+
+	if j.ExpectBody != "" {
+		re, err := regexp.Compile(j.ExpectBody)
+		if err != nil {
+			return fmt.Errorf("invalid expect-body %q: %s", j.ExpectBody, err)
+		}
+
+		if !re.Match(body) {
+			return fmt.Errorf("error response body does not match %q", j.ExpectBody)
+		}
+	}
+
+	return nil
+}
+
+func (j *HTTPJob) buildRequest() (*http.Request, error) {
+	if j.URL == "" {
+		return nil, errors.New("url is required")
+	}
+
+	if j.Body != "" && j.BodyFile != "" {
+		return nil, errors.New("body and body-file can not be used together")
+	}
+
+	var body io.Reader
+	if j.Body != "" {
+		body = strings.NewReader(j.Body)
+	}
+
+	if j.BodyFile != "" {
+		content, err := os.ReadFile(j.BodyFile)
+		if err != nil {
+			return nil, fmt.Errorf("error reading body file: %s", err)
+		}
+
+		body = bytes.NewReader(content)
+	}
+
+	req, err := http.NewRequest(j.Method, j.URL, body)
+	if err != nil {
+		return nil, fmt.Errorf("invalid request: %s", err)
+	}
+
+	for _, h := range j.Header {
+		name, value, ok := strings.Cut(h, ":")
+		if !ok || strings.TrimSpace(name) == "" {
+			return nil, fmt.Errorf("invalid header %q, expected Name: value", h)
+		}
+
+		req.Header.Add(strings.TrimSpace(name), strings.TrimSpace(value))
+	}
+
+	// the Host header sets the host of the request instead
+	if host := req.Header.Get("Host"); host != "" {
+		req.Host = host
+	}
+
+	return req, nil
+}
+
+// expectedStatus returns if the status code is accepted, by code or by class,
+// e.g. `4xx`
+func (j *HTTPJob) expectedStatus(code int) (bool, error) {
+	if len(j.ExpectStatus) == 0 {
+		return code >= 200 && code < 300, nil
+	}
+
+	for _, s := range j.ExpectStatus {
+		if class, ok := strings.CutSuffix(strings.ToLower(s), "xx"); ok && len(class) == 1 {
+			n, err := strconv.Atoi(class)
+			if err != nil {
+				return false, fmt.Errorf("invalid expect-status %q", s)
+			}
+
+			if code/100 == n {
+				return true, nil
+			}
+
+			continue
+		}
+
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			return false, fmt.Errorf("invalid expect-status %q", s)
+		}
+
+		if code == n {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+func (j *HTTPJob) Hash() string {
+	var hash string
+	getHash(reflect.TypeOf(j).Elem(), reflect.ValueOf(j).Elem(), &hash)
+	return hash
+}
@@ -0,0 +1,148 @@
+package core
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+type SuiteHTTPJob struct {
+	server *httptest.Server
+	// last request received by the server, with its body
+	request *http.Request
+	body    string
+}
+
+var _ = Suite(&SuiteHTTPJob{})
+
+func (s *SuiteHTTPJob) SetUpTest(c *C) {
+	s.request, s.body = nil, ""
+	s.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		s.request, s.body = r, string(body)
+
+		switch r.URL.Path {
+		case "/missing":
+			w.WriteHeader(http.StatusNotFound)
+			io.WriteString(w, "not found")
+		case "/slow":
+			time.Sleep(200 * time.Millisecond)
+		default:
+			io.WriteString(w, `{"status":"ok"}`)
+		}
+	}))
+}
+
+func (s *SuiteHTTPJob) TearDownTest(c *C) {
+	s.server.Close()
+}
+
+func (s *SuiteHTTPJob) newJob(path string) *HTTPJob {
+	job := NewHTTPJob()
+	job.Name = "test"
+	job.Method = "GET"
+	job.URL = s.server.URL + path
+	job.Timeout = "30s"
+
+	return job
+}
+
+func (s *SuiteHTTPJob) TestRun(c *C) {
+	job := s.newJob("/hooks/cleanup")
+	job.Method = "POST"
+	job.Header = []string{"Content-Type: application/json", "Authorization: Bearer secret"}
+	job.Body = `{"days":30}`
+
+	ctx := &Context{Job: job, Execution: NewExecution()}
+	c.Assert(job.Run(ctx), IsNil)
+	c.Assert(ctx.Execution.OutputStream.String(), Equals, `{"status":"ok"}`)
+
+	c.Assert(s.request.Method, Equals, "POST")
+	c.Assert(s.request.URL.Path, Equals, "/hooks/cleanup")
+	c.Assert(s.request.Header.Get("Content-Type"), Equals, "application/json")
+	c.Assert(s.request.Header.Get("Authorization"), Equals, "Bearer secret")
+	c.Assert(s.body, Equals, `{"days":30}`)
+	c.Assert(job.GetCommand(), Equals, "POST "+job.URL)
+}
+
+func (s *SuiteHTTPJob) TestRunBodyFile(c *C) {
+	file := filepath.Join(c.MkDir(), "body.json")
+	c.Assert(os.WriteFile(file, []byte(`{"days":7}`), 0644), IsNil)
+
+	job := s.newJob("/")
+	job.Method = "PUT"
+	job.BodyFile = file
+
+	c.Assert(job.Run(&Context{Job: job, Execution: NewExecution()}), IsNil)
+	c.Assert(s.body, Equals, `{"days":7}`)
+
+	job.Body = "foo"
+	err := job.Run(&Context{Job: job, Execution: NewExecution()})
+	c.Assert(err, ErrorMatches, "body and body-file can not be used together")
+}
+
+func (s *SuiteHTTPJob) TestRunStatus(c *C) {
+	testcases := []struct {
+		Path   string
+		Expect []string
+		Error  string
+	}{
+		{"/", nil, ""},
+		{"/missing", nil, "error unexpected status code: 404"},
+		{"/missing", []string{"404"}, ""},
+		{"/missing", []string{"200", "4xx"}, ""},
+		{"/", []string{"204"}, "error unexpected status code: 200"},
+		{"/", []string{"2x"}, `invalid expect-status "2x"`},
+	}
+
+	for _, t := range testcases {
+		job := s.newJob(t.Path)
+		job.ExpectStatus = t.Expect
+
+		ctx := &Context{Job: job, Execution: NewExecution()}
+		err := job.Run(ctx)
+		if t.Error == "" {
+			c.Assert(err, IsNil, Commentf("%s %v", t.Path, t.Expect))
+		} else {
+			c.Assert(err, ErrorMatches, t.Error, Commentf("%s %v", t.Path, t.Expect))
+		}
+	}
+
+	// the response is captured even if the execution failed
+	job := s.newJob("/missing")
+	ctx := &Context{Job: job, Execution: NewExecution()}
+	c.Assert(job.Run(ctx), NotNil)
+	c.Assert(ctx.Execution.OutputStream.String(), Equals, "not found")
+}
+
+func (s *SuiteHTTPJob) TestRunExpectBody(c *C) {
+	job := s.newJob("/")
+	job.ExpectBody = `"status":\s*"ok"`
+	c.Assert(job.Run(&Context{Job: job, Execution: NewExecution()}), IsNil)
+
+	job.ExpectBody = `"status":\s*"failed"`
+	err := job.Run(&Context{Job: job, Execution: NewExecution()})
+	c.Assert(err, ErrorMatches, `error response body does not match .*`)
+}
+
+func (s *SuiteHTTPJob) TestRunTimeout(c *C) {
+	job := s.newJob("/slow")
+	job.Timeout = "50ms"
+
+	err := job.Run(&Context{Job: job, Execution: NewExecution()})
+	c.Assert(err, ErrorMatches, "error calling .*Timeout exceeded.*")
+}
+
+func (s *SuiteHTTPJob) TestRunInvalidHeader(c *C) {
+	job := s.newJob("/")
+	job.Header = []string{"Content-Type"}
+
+	err := job.Run(&Context{Job: job, Execution: NewExecution()})
+	c.Assert(err, ErrorMatches, `invalid header "Content-Type", expected Name: value`)
+	c.Assert(s.request, IsNil)
+}
@@ -1,18 +1,58 @@
 package core
 
 import (
+	"errors"
+	"fmt"
+	"os"
 	"os/exec"
 	"reflect"
+	"strings"
+	"time"
 
 	"github.com/gobs/args"
 )
 
+// Policies of inheritance of the environment of chadburn by the local jobs,
+// a comma separated list of variable names is also accepted
+const (
+	InheritEnvAll  = "all"
+	InheritEnvNone = "none"
+)
+
 type LocalJob struct {
-	BareJob     `mapstructure:",squash"`
-	Dir         string
-	Environment []string
+	BareJob `mapstructure:",squash"`
+	Dir     string
+	// Template renders the command and the environment variables as
+	// templates on every execution, see TemplateData
+	Template bool `default:"false" hash:"true"`
+	// Environment variables in `KEY=value` form, they take precedence over
+	// the ones read from EnvFile, which is read on every execution, and the
+	// ones inherited from chadburn according to InheritEnv
+	Environment []string `hash:"true"`
+	EnvFile     string   `gcfg:"env-file" mapstructure:"env-file" hash:"true"`
+	InheritEnv  string   `gcfg:"inherit-env" mapstructure:"inherit-env" default:"all" hash:"true"`
+	// Script is run by Shell instead of the command, every value is a line
+	Script []string `hash:"true"`
+	Shell  string   `default:"/bin/sh" hash:"true"`
+
+	// User and Group run the command with another identity, by name or ID,
+	// chadburn has to run as root
+	User  string `hash:"true"`
+	Group string `hash:"true"`
+	// Resource limits of the command, CPUTime is a duration and Memory a size
+	// as in `docker run --memory`
+	CPUTime   string `gcfg:"cpu-time" mapstructure:"cpu-time" hash:"true"`
+	Memory    string `hash:"true"`
+	OpenFiles int    `gcfg:"open-files" mapstructure:"open-files" hash:"true"`
+	// Cgroup is a cgroup v2 directory, every execution runs in a cgroup of
+	// its own created inside it
+	Cgroup string `hash:"true"`
 }
 
+// localWaitDelay is how long the output of the background processes started
+// by a local job is read once the command exits, they are killed afterwards
+const localWaitDelay = time.Second
+
 func NewLocalJob() *LocalJob {
 	return &LocalJob{}
 }
@@ -23,24 +63,110 @@ func (j *LocalJob) Run(ctx *Context) error {
 		return err
 	}
 
-	return cmd.Run()
+	if err := j.limit(cmd); err != nil {
+		return err
+	}
+
+	release, err := j.isolate(cmd, ctx.Execution)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	err = cmd.Run()
+	if errors.Is(err, exec.ErrWaitDelay) {
+		ctx.Log("Background processes of the command were still running, they were killed")
+		return nil
+	}
+
+	return err
 }
 
 func (j *LocalJob) buildCommand(ctx *Context) (*exec.Cmd, error) {
-	args := args.GetArgs(j.Command)
+	data := newTemplateData(j, ctx.Execution, j.Template)
+	command, err := renderTemplate(j.Command, data)
+	if err != nil {
+		return nil, err
+	}
+
+	args := args.GetArgs(command)
+	if len(j.Script) > 0 {
+		if args, err = scriptShell(j.Command, j.Shell); err != nil {
+			return nil, err
+		}
+	}
+
 	bin, err := exec.LookPath(args[0])
 	if err != nil {
 		return nil, err
 	}
 
-	return &exec.Cmd{
+	env, err := j.environment(ctx.Execution, data)
+	if err != nil {
+		return nil, err
+	}
+
+	cmd := &exec.Cmd{
 		Path:   bin,
 		Args:   args,
 		Stdout: ctx.Execution.OutputStream,
 		Stderr: ctx.Execution.ErrorStream,
-		Env:    j.Environment,
+		Env:    env,
 		Dir:    j.Dir,
-	}, nil
+	}
+
+	if len(j.Script) > 0 {
+		cmd.Stdin = strings.NewReader(joinScript(j.Script))
+	}
+
+	return cmd, nil
+}
+
+// environment returns the variables of the command: the inherited ones,
+// overridden by the ones of EnvFile and the rendered Environment, and the
+// variables of the execution
+func (j *LocalJob) environment(e *Execution, data *TemplateData) ([]string, error) {
+	inherited, err := inheritEnvironment(j.InheritEnv)
+	if err != nil {
+		return nil, err
+	}
+
+	environment, err := renderTemplates(j.Environment, data)
+	if err != nil {
+		return nil, err
+	}
+
+	vars, err := buildEnvironment(j.EnvFile, environment)
+	if err != nil {
+		return nil, err
+	}
+
+	env := mergeEnvironment(inherited, vars)
+	return mergeEnvironment(env, executionEnvironment(j, e)), nil
+}
+
+// inheritEnvironment returns the variables of chadburn allowed by the policy
+func inheritEnvironment(policy string) ([]string, error) {
+	switch policy {
+	case "", InheritEnvAll:
+		return os.Environ(), nil
+	case InheritEnvNone:
+		return nil, nil
+	}
+
+	var vars []string
+	for _, name := range strings.Split(policy, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" || strings.ContainsAny(name, "= \t") {
+			return nil, fmt.Errorf("invalid inherit-env %q", policy)
+		}
+
+		if value, ok := os.LookupEnv(name); ok {
+			vars = append(vars, name+"="+value)
+		}
+	}
+
+	return vars, nil
 }
 
 func (j *LocalJob) Hash() string {
@@ -0,0 +1,207 @@
+//go:build linux
+
+package core
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// isolate runs the command in a process group of its own, with the identity
+// and in the cgroup of the job. The returned function kills what is left of
+// the process group, and of the cgroup, once the command exits.
+func (j *LocalJob) isolate(cmd *exec.Cmd, e *Execution) (func(), error) {
+	attr := &syscall.SysProcAttr{Setpgid: true}
+
+	if j.User != "" || j.Group != "" {
+		credential, err := lookupCredential(j.User, j.Group)
+		if err != nil {
+			return nil, err
+		}
+
+		attr.Credential = credential
+	}
+
+	var cgroup string
+	var cgroupFD int
+	if j.Cgroup != "" {
+		var err error
+		if cgroup, err = j.createCgroup(e); err != nil {
+			return nil, err
+		}
+
+		if cgroupFD, err = syscall.Open(cgroup, syscall.O_RDONLY|syscall.O_DIRECTORY, 0); err != nil {
+			removeCgroup(cgroup)
+			return nil, fmt.Errorf("error opening cgroup: %s", err)
+		}
+
+		attr.UseCgroupFD = true
+		attr.CgroupFD = cgroupFD
+	}
+
+	cmd.SysProcAttr = attr
+	cmd.WaitDelay = localWaitDelay
+
+	return func() {
+		if cmd.Process != nil {
+			syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+		}
+
+		if cgroup != "" {
+			syscall.Close(cgroupFD)
+			removeCgroup(cgroup)
+		}
+	}, nil
+}
+
+// limitShell sets the resource limits of the local jobs with its ulimit
+// builtin before executing their command, so they apply from its start
+const limitShell = "/bin/sh"
+
+// limit runs the command through limitShell when the job has resource limits
+func (j *LocalJob) limit(cmd *exec.Cmd) error {
+	var limits []string
+	if j.CPUTime != "" {
+		d, err := time.ParseDuration(j.CPUTime)
+		if err != nil || d < time.Second {
+			return fmt.Errorf("invalid cpu-time %q", j.CPUTime)
+		}
+
+		limits = append(limits, fmt.Sprintf("ulimit -t %d", d/time.Second))
+	}
+
+	// in a cgroup the memory is limited by the cgroup instead
+	if j.Memory != "" && j.Cgroup == "" {
+		memory, err := parseMemory(j.Memory)
+		if err != nil {
+			return err
+		}
+
+		limits = append(limits, fmt.Sprintf("ulimit -v %d", memory/1024))
+	}
+
+	if j.OpenFiles > 0 {
+		limits = append(limits, fmt.Sprintf("ulimit -n %d", j.OpenFiles))
+	}
+
+	if len(limits) == 0 {
+		return nil
+	}
+
+	// the command fails if a limit can not be set
+	script := strings.Join(limits, " && ") + ` && exec "$@"`
+	cmd.Args = append([]string{limitShell, "-c", script, limitShell, cmd.Path}, cmd.Args[1:]...)
+	cmd.Path = limitShell
+
+	return nil
+}
+
+// lookupCredential returns the credential of the user and group, given by
+// name or ID. Without group the primary group of the user is used, and
+// without user the one of chadburn.
+func lookupCredential(name, group string) (*syscall.Credential, error) {
+	credential := &syscall.Credential{
+		Uid: uint32(os.Getuid()),
+		Gid: uint32(os.Getgid()),
+	}
+
+	if name != "" {
+		u, err := user.Lookup(name)
+		if err != nil {
+			if u, err = user.LookupId(name); err != nil {
+				return nil, fmt.Errorf("unknown user %q", name)
+			}
+		}
+
+		uid, _ := strconv.ParseUint(u.Uid, 10, 32)
+		gid, _ := strconv.ParseUint(u.Gid, 10, 32)
+		credential.Uid, credential.Gid = uint32(uid), uint32(gid)
+
+		// the supplementary groups of the user, as after a login
+		groups, _ := u.GroupIds()
+		credential.Groups = []uint32{}
+		for _, g := range groups {
+			if id, err := strconv.ParseUint(g, 10, 32); err == nil {
+				credential.Groups = append(credential.Groups, uint32(id))
+			}
+		}
+	}
+
+	if group != "" {
+		g, err := user.LookupGroup(group)
+		if err != nil {
+			if g, err = user.LookupGroupId(group); err != nil {
+				return nil, fmt.Errorf("unknown group %q", group)
+			}
+		}
+
+		gid, _ := strconv.ParseUint(g.Gid, 10, 32)
+		credential.Gid = uint32(gid)
+	}
+
+	return credential, nil
+}
+
+// createCgroup creates the cgroup of the execution inside the cgroup of the
+// job, with its memory limit
+func (j *LocalJob) createCgroup(e *Execution) (string, error) {
+	name := strings.Map(func(r rune) rune {
+		if r == '/' || r == '.' {
+			return '_'
+		}
+
+		return r
+	}, j.Name)
+
+	path := filepath.Join(j.Cgroup, name+"-"+e.ID)
+	if err := os.Mkdir(path, 0755); err != nil {
+		return "", fmt.Errorf("error creating cgroup: %s", err)
+	}
+
+	if j.Memory != "" {
+		memory, err := parseMemory(j.Memory)
+		if err != nil {
+			removeCgroup(path)
+			return "", err
+		}
+
+		max := strconv.FormatInt(memory, 10)
+		if err := os.WriteFile(filepath.Join(path, "memory.max"), []byte(max), 0644); err != nil {
+			removeCgroup(path)
+			return "", fmt.Errorf("error setting cgroup memory limit, is the memory controller enabled? %s", err)
+		}
+	}
+
+	return path, nil
+}
+
+// removeCgroup kills the processes left in the cgroup and removes it
+func removeCgroup(path string) {
+	if err := os.WriteFile(filepath.Join(path, "cgroup.kill"), []byte("1"), 0644); err != nil {
+		// cgroup.kill is only available since linux 5.14
+		procs, _ := os.ReadFile(filepath.Join(path, "cgroup.procs"))
+		for _, p := range strings.Fields(string(procs)) {
+			if pid, err := strconv.Atoi(p); err == nil {
+				syscall.Kill(pid, syscall.SIGKILL)
+			}
+		}
+	}
+
+	// the cgroup can only be removed once its processes are gone
+	for i := 0; i < 50; i++ {
+		err := os.Remove(path)
+		if err == nil || errors.Is(err, os.ErrNotExist) {
+			return
+		}
+
+		time.Sleep(100 * time.Millisecond)
+	}
+}
@@ -0,0 +1,152 @@
+//go:build linux
+
+package core
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	logging "github.com/op/go-logging"
+	. "gopkg.in/check.v1"
+)
+
+func (s *SuiteLocalJob) newContext(job Job) *Context {
+	ctx := &Context{Job: job, Execution: NewExecution()}
+	ctx.Logger = logging.MustGetLogger("chadburn")
+
+	return ctx
+}
+
+func (s *SuiteLocalJob) TestRunKillsProcessGroup(c *C) {
+	pidFile := filepath.Join(c.MkDir(), "pid")
+
+	job := &LocalJob{Shell: "/bin/sh"}
+	job.Name = "test"
+	job.Script = []string{"sleep 30 > /dev/null 2>&1 &", "echo $! > " + pidFile}
+
+	start := time.Now()
+	c.Assert(job.Run(s.newContext(job)), IsNil)
+	c.Assert(time.Since(start) < 5*time.Second, Equals, true)
+
+	content, err := os.ReadFile(pidFile)
+	c.Assert(err, IsNil)
+	pid, err := strconv.Atoi(strings.TrimSpace(string(content)))
+	c.Assert(err, IsNil)
+	for i := 0; i < 10 && processAlive(pid); i++ {
+		time.Sleep(100 * time.Millisecond)
+	}
+	c.Assert(processAlive(pid), Equals, false)
+}
+
+func (s *SuiteLocalJob) TestRunBackgroundOutput(c *C) {
+	// the background process keeps the output open, it is killed after the
+	// wait delay
+	job := &LocalJob{Shell: "/bin/sh"}
+	job.Name = "test"
+	job.Script = []string{"sleep 30 &", "echo done"}
+
+	ctx := s.newContext(job)
+	start := time.Now()
+	c.Assert(job.Run(ctx), IsNil)
+	c.Assert(time.Since(start) < 5*time.Second, Equals, true)
+	c.Assert(ctx.Execution.OutputStream.String(), Equals, "done\n")
+}
+
+func (s *SuiteLocalJob) TestRunLimits(c *C) {
+	// the limits are set before the command is executed, it sees them
+	job := &LocalJob{CPUTime: "1m", Memory: "512m", OpenFiles: 64}
+	job.Name = "test"
+	job.Command = "cat /proc/self/limits"
+
+	ctx := s.newContext(job)
+	c.Assert(job.Run(ctx), IsNil)
+
+	limits := ctx.Execution.OutputStream.String()
+	c.Assert(limits, Matches, `(?s).*Max cpu time\s+60\s+60 .*`)
+	c.Assert(limits, Matches, `(?s).*Max address space\s+536870912\s+536870912 .*`)
+	c.Assert(limits, Matches, `(?s).*Max open files\s+64\s+64 .*`)
+
+	// the command is not started with invalid limits
+	job.CPUTime = "10ms"
+	c.Assert(job.Run(s.newContext(job)), ErrorMatches, `invalid cpu-time "10ms"`)
+}
+
+func (s *SuiteLocalJob) TestRunUser(c *C) {
+	if os.Getuid() != 0 {
+		c.Skip("running as another user requires root")
+	}
+
+	job := &LocalJob{User: "nobody", Group: "0", Shell: "/bin/sh"}
+	job.Name = "test"
+	job.Script = []string{"id -u", "id -g"}
+
+	ctx := s.newContext(job)
+	c.Assert(job.Run(ctx), IsNil)
+	c.Assert(ctx.Execution.OutputStream.String(), Equals, "65534\n0\n")
+
+	// the limits are set by the shell running as the user
+	job.OpenFiles = 64
+	job.Script = []string{"id -u", "ulimit -n"}
+	ctx = s.newContext(job)
+	c.Assert(job.Run(ctx), IsNil)
+	c.Assert(ctx.Execution.OutputStream.String(), Equals, "65534\n64\n")
+
+	job.User = "no-such-user"
+	c.Assert(job.Run(s.newContext(job)), ErrorMatches, `unknown user "no-such-user"`)
+}
+
+func (s *SuiteLocalJob) TestRunCgroup(c *C) {
+	parent := testCgroup(c)
+	defer os.Remove(parent)
+
+	job := &LocalJob{Cgroup: parent}
+	job.Name = "test"
+	job.Command = "cat /proc/self/cgroup"
+
+	ctx := s.newContext(job)
+	c.Assert(job.Run(ctx), IsNil)
+	c.Assert(ctx.Execution.OutputStream.String(), Matches, `(?s).*/chadburn-test/test-`+ctx.Execution.ID+"\n.*")
+
+	// the cgroup of the execution is removed
+	entries, err := os.ReadDir(parent)
+	c.Assert(err, IsNil)
+	for _, e := range entries {
+		c.Assert(e.IsDir(), Equals, false)
+	}
+}
+
+// testCgroup creates a cgroup for the test in the cgroup v2 hierarchy, the
+// test is skipped if there is none
+func testCgroup(c *C) string {
+	for _, root := range []string{"/sys/fs/cgroup", "/sys/fs/cgroup/unified"} {
+		var fs syscall.Statfs_t
+		if err := syscall.Statfs(root, &fs); err != nil || fs.Type != 0x63677270 {
+			continue
+		}
+
+		path := filepath.Join(root, "chadburn-test")
+		if err := os.Mkdir(path, 0755); err != nil {
+			c.Skip("unable to create a cgroup: " + err.Error())
+		}
+
+		return path
+	}
+
+	c.Skip("no cgroup v2 hierarchy")
+	return ""
+}
+
+// processAlive returns if the process is running, zombies are not
+func processAlive(pid int) bool {
+	stat, err := os.ReadFile("/proc/" + strconv.Itoa(pid) + "/stat")
+	if err != nil {
+		return false
+	}
+
+	fields := strings.Fields(string(stat))
+	return len(fields) > 2 && fields[2] != "Z"
+}
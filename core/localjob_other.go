@@ -0,0 +1,26 @@
+//go:build !linux
+
+package core
+
+import (
+	"errors"
+	"os/exec"
+)
+
+var errIsolationUnsupported = errors.New("user, group, cpu-time, memory, open-files and cgroup are only supported on linux")
+
+func (j *LocalJob) isolate(cmd *exec.Cmd, e *Execution) (func(), error) {
+	if j.User != "" || j.Group != "" || j.Cgroup != "" {
+		return nil, errIsolationUnsupported
+	}
+
+	return func() {}, nil
+}
+
+func (j *LocalJob) limit(cmd *exec.Cmd) error {
+	if j.CPUTime != "" || j.Memory != "" || j.OpenFiles > 0 {
+		return errIsolationUnsupported
+	}
+
+	return nil
+}
@@ -1,6 +1,11 @@
 package core
 
 import (
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
 	"github.com/armon/circbuf"
 
 	. "gopkg.in/check.v1"
@@ -22,3 +27,127 @@ func (s *SuiteLocalJob) TestRun(c *C) {
 	c.Assert(err, IsNil)
 	c.Assert(b.String(), Equals, "foo bar\n")
 }
+
+func (s *SuiteLocalJob) TestRunScript(c *C) {
+	job := &LocalJob{Shell: "/bin/sh"}
+	job.Script = []string{
+		"set -e",
+		"for i in 1 2; do echo \"line $i\"; done | tr a-z A-Z",
+		"echo 'quotes \"kept\"' > /dev/stderr",
+	}
+
+	e := NewExecution()
+	err := job.Run(&Context{Execution: e})
+	c.Assert(err, IsNil)
+	c.Assert(e.OutputStream.String(), Equals, "LINE 1\nLINE 2\n")
+	c.Assert(e.ErrorStream.String(), Equals, "quotes \"kept\"\n")
+
+	job.Script = []string{"exit 3"}
+	err = job.Run(&Context{Execution: NewExecution()})
+	c.Assert(err, ErrorMatches, "exit status 3")
+}
+
+func (s *SuiteLocalJob) TestRunEnvironment(c *C) {
+	os.Setenv("CHADBURN_TEST_INHERITED", "inherited")
+	os.Setenv("CHADBURN_TEST_OVERRIDDEN", "inherited")
+	defer os.Unsetenv("CHADBURN_TEST_INHERITED")
+	defer os.Unsetenv("CHADBURN_TEST_OVERRIDDEN")
+
+	envFile := filepath.Join(c.MkDir(), "env")
+	content := "CHADBURN_TEST_OVERRIDDEN=file\nCHADBURN_TEST_FILE=file\n"
+	c.Assert(os.WriteFile(envFile, []byte(content), 0644), IsNil)
+
+	testcases := []struct {
+		InheritEnv string
+		Expected   []string
+		Missing    []string
+	}{
+		{
+			InheritEnv: "all",
+			Expected:   []string{"CHADBURN_TEST_INHERITED=inherited", "CHADBURN_TEST_FILE=file"},
+		},
+		{
+			InheritEnv: "none",
+			Expected:   []string{"CHADBURN_TEST_FILE=file"},
+			Missing:    []string{"CHADBURN_TEST_INHERITED", "PATH"},
+		},
+		{
+			InheritEnv: "PATH, CHADBURN_TEST_INHERITED",
+			Expected:   []string{"CHADBURN_TEST_INHERITED=inherited", "PATH=" + os.Getenv("PATH")},
+			Missing:    []string{"HOME"},
+		},
+	}
+
+	for _, t := range testcases {
+		job := &LocalJob{InheritEnv: t.InheritEnv, EnvFile: envFile}
+		job.Name = "test"
+		job.Command = "env"
+		job.Environment = []string{"CHADBURN_TEST_OVERRIDDEN=environment", "CHADBURN_JOB_NAME=foo"}
+
+		e := NewExecution()
+		c.Assert(job.Run(&Context{Execution: e}), IsNil)
+
+		env := make(map[string]string)
+		for _, v := range strings.Split(strings.TrimSpace(e.OutputStream.String()), "\n") {
+			name, value, _ := strings.Cut(v, "=")
+			env[name] = value
+		}
+
+		expected := append(t.Expected,
+			"CHADBURN_TEST_OVERRIDDEN=environment",
+			"CHADBURN_JOB_NAME=test",
+			"CHADBURN_EXECUTION_ID="+e.ID,
+			"CHADBURN_ATTEMPT=1",
+			"CHADBURN_TRIGGER=manual",
+		)
+		for _, v := range expected {
+			name, value, _ := strings.Cut(v, "=")
+			c.Assert(env[name], Equals, value, Commentf("inherit-env %s: %s", t.InheritEnv, name))
+		}
+
+		for _, name := range t.Missing {
+			_, ok := env[name]
+			c.Assert(ok, Equals, false, Commentf("inherit-env %s: %s", t.InheritEnv, name))
+		}
+	}
+
+	job := &LocalJob{InheritEnv: "PATH,FOO=bar"}
+	job.Command = "env"
+	err := job.Run(&Context{Execution: NewExecution()})
+	c.Assert(err, ErrorMatches, `invalid inherit-env "PATH,FOO=bar"`)
+}
+
+func (s *SuiteLocalJob) TestRunTemplate(c *C) {
+	job := &LocalJob{Template: true}
+	job.Name = "backup"
+	job.Command = `echo dump-{{ date "2006-01-02" .ScheduledTime }}.sql $REPORT`
+	job.Environment = []string{"REPORT=report-{{ .ExecutionID }}"}
+
+	e := NewExecution()
+	e.ScheduledTime = time.Date(2026, 10, 16, 3, 0, 0, 0, time.UTC)
+	c.Assert(job.Run(&Context{Job: job, Execution: e}), IsNil)
+	// the command is run without shell, the variable is not expanded
+	c.Assert(e.OutputStream.String(), Equals, "dump-2026-10-16.sql $REPORT\n")
+
+	job.Command = "env"
+	e = NewExecution()
+	c.Assert(job.Run(&Context{Job: job, Execution: e}), IsNil)
+	c.Assert(e.OutputStream.String(), Matches, "(?s).*\nREPORT=report-"+e.ID+"\n.*")
+
+	job.Command = "echo {{ .Foo }}"
+	err := job.Run(&Context{Job: job, Execution: NewExecution()})
+	c.Assert(err, ErrorMatches, `error rendering template "echo {{ .Foo }}": .*`)
+
+	// without templating the values are left untouched
+	job.Template = false
+	job.Command = "echo {{.Names}}"
+	job.Environment = []string{"FORMAT={{json .}}"}
+	e = NewExecution()
+	c.Assert(job.Run(&Context{Job: job, Execution: e}), IsNil)
+	c.Assert(e.OutputStream.String(), Equals, "{{.Names}}\n")
+
+	job.Command = "env"
+	e = NewExecution()
+	c.Assert(job.Run(&Context{Job: job, Execution: e}), IsNil)
+	c.Assert(e.OutputStream.String(), Matches, "(?s).*\nFORMAT={{json \\.}}\n.*")
+}
@@ -0,0 +1,298 @@
+package core
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/docker/docker/api/types/mount"
+	"github.com/docker/docker/api/types/swarm"
+	"github.com/docker/go-units"
+	docker "github.com/fsouza/go-dockerclient"
+)
+
+// parseLabels converts a list of `key=value` labels, as in
+// `docker run --label`, into a map. A bare `key` gets an empty value.
+func parseLabels(labels []string) map[string]string {
+	if len(labels) == 0 {
+		return nil
+	}
+
+	m := make(map[string]string, len(labels))
+	for _, l := range labels {
+		key, value, _ := strings.Cut(l, "=")
+		m[key] = value
+	}
+
+	return m
+}
+
+// parseMemory parses a memory size such as `512m` or `2g`, as in
+// `docker run --memory`. An empty value means no limit.
+func parseMemory(memory string) (int64, error) {
+	if memory == "" {
+		return 0, nil
+	}
+
+	bytes, err := units.RAMInBytes(memory)
+	if err != nil {
+		return 0, fmt.Errorf("invalid memory %q: %s", memory, err)
+	}
+
+	return bytes, nil
+}
+
+// parseCPUs parses a number of CPUs such as `1.5` into nano CPUs, as in
+// `docker run --cpus`. An empty value means no limit.
+func parseCPUs(cpus string) (int64, error) {
+	if cpus == "" {
+		return 0, nil
+	}
+
+	n, err := strconv.ParseFloat(cpus, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid cpus %q", cpus)
+	}
+
+	return int64(n * 1e9), nil
+}
+
+// parseDevices parses devices in the format of `docker run --device`,
+// `host[:container[:permissions]]`
+func parseDevices(devices []string) ([]docker.Device, error) {
+	var parsed []docker.Device
+	for _, d := range devices {
+		parts := strings.Split(d, ":")
+		if parts[0] == "" || len(parts) > 3 {
+			return nil, fmt.Errorf("invalid device %q", d)
+		}
+
+		device := docker.Device{
+			PathOnHost:        parts[0],
+			PathInContainer:   parts[0],
+			CgroupPermissions: "rwm",
+		}
+
+		if len(parts) > 1 && parts[1] != "" {
+			device.PathInContainer = parts[1]
+		}
+
+		if len(parts) > 2 {
+			device.CgroupPermissions = parts[2]
+		}
+
+		parsed = append(parsed, device)
+	}
+
+	return parsed, nil
+}
+
+// parseTmpfs parses tmpfs mounts in the format of `docker run --tmpfs`,
+// `path[:options]`
+func parseTmpfs(tmpfs []string) map[string]string {
+	if len(tmpfs) == 0 {
+		return nil
+	}
+
+	m := make(map[string]string, len(tmpfs))
+	for _, t := range tmpfs {
+		path, options, _ := strings.Cut(t, ":")
+		m[path] = options
+	}
+
+	return m
+}
+
+// parseMounts parses mounts in the format of `docker run --mount`, e.g.
+// `type=volume,source=backups,target=/backups,readonly`. The type defaults
+// to volume.
+func parseMounts(mounts []string) ([]docker.HostMount, error) {
+	var parsed []docker.HostMount
+	for _, m := range mounts {
+		mount, err := parseMount(m)
+		if err != nil {
+			return nil, fmt.Errorf("invalid mount %q: %s", m, err)
+		}
+
+		parsed = append(parsed, mount)
+	}
+
+	return parsed, nil
+}
+
+func parseMount(m string) (docker.HostMount, error) {
+	mount := docker.HostMount{Type: "volume"}
+	for _, field := range strings.Split(m, ",") {
+		key, value, hasValue := strings.Cut(strings.TrimSpace(field), "=")
+
+		var err error
+		switch strings.ToLower(key) {
+		case "type":
+			mount.Type = value
+		case "source", "src":
+			mount.Source = value
+		case "target", "destination", "dst":
+			mount.Target = value
+		case "readonly", "ro":
+			mount.ReadOnly = true
+			if hasValue {
+				mount.ReadOnly, err = strconv.ParseBool(value)
+			}
+		case "bind-propagation":
+			mount.BindOptions = &docker.BindOptions{Propagation: value}
+		case "volume-nocopy":
+			if mount.VolumeOptions == nil {
+				mount.VolumeOptions = &docker.VolumeOptions{}
+			}
+
+			mount.VolumeOptions.NoCopy = true
+			if hasValue {
+				mount.VolumeOptions.NoCopy, err = strconv.ParseBool(value)
+			}
+		case "tmpfs-size":
+			if mount.TempfsOptions == nil {
+				mount.TempfsOptions = &docker.TempfsOptions{}
+			}
+
+			mount.TempfsOptions.SizeBytes, err = units.RAMInBytes(value)
+		case "tmpfs-mode":
+			if mount.TempfsOptions == nil {
+				mount.TempfsOptions = &docker.TempfsOptions{}
+			}
+
+			var mode uint64
+			mode, err = strconv.ParseUint(value, 8, 32)
+			mount.TempfsOptions.Mode = int(mode)
+		default:
+			return mount, fmt.Errorf("unknown option %q", key)
+		}
+
+		if err != nil {
+			return mount, fmt.Errorf("invalid value of %q: %s", key, err)
+		}
+	}
+
+	if mount.Target == "" {
+		return mount, fmt.Errorf("target is required")
+	}
+
+	return mount, nil
+}
+
+// parseServiceMounts parses mounts in the format of `docker service create
+// --mount`, the same as the one of `docker run --mount`
+func parseServiceMounts(mounts []string) ([]mount.Mount, error) {
+	hostMounts, err := parseMounts(mounts)
+	if err != nil {
+		return nil, err
+	}
+
+	var parsed []mount.Mount
+	for _, m := range hostMounts {
+		sm := mount.Mount{
+			Type:     mount.Type(m.Type),
+			Source:   m.Source,
+			Target:   m.Target,
+			ReadOnly: m.ReadOnly,
+		}
+
+		if m.BindOptions != nil {
+			sm.BindOptions = &mount.BindOptions{Propagation: mount.Propagation(m.BindOptions.Propagation)}
+		}
+
+		if m.VolumeOptions != nil {
+			sm.VolumeOptions = &mount.VolumeOptions{NoCopy: m.VolumeOptions.NoCopy}
+		}
+
+		if m.TempfsOptions != nil {
+			sm.TmpfsOptions = &mount.TmpfsOptions{
+				SizeBytes: m.TempfsOptions.SizeBytes,
+				Mode:      os.FileMode(m.TempfsOptions.Mode),
+			}
+		}
+
+		parsed = append(parsed, sm)
+	}
+
+	return parsed, nil
+}
+
+// fileReference is a secret or config mounted as a file in the containers of
+// a service
+type fileReference struct {
+	Source string
+	Target string
+	UID    string
+	GID    string
+	Mode   os.FileMode
+}
+
+// parseFileReferences parses secrets or configs in the format of `docker
+// service create --secret`, either a bare name or
+// `source=name,target=path,uid=0,gid=0,mode=0400`. The target defaults to
+// the name and the mode to 0444.
+func parseFileReferences(refs []string) ([]fileReference, error) {
+	var parsed []fileReference
+	for _, r := range refs {
+		ref, err := parseFileReference(r)
+		if err != nil {
+			return nil, fmt.Errorf("invalid reference %q: %s", r, err)
+		}
+
+		parsed = append(parsed, ref)
+	}
+
+	return parsed, nil
+}
+
+func parseFileReference(r string) (fileReference, error) {
+	ref := fileReference{UID: "0", GID: "0", Mode: 0444}
+	if !strings.Contains(r, "=") {
+		ref.Source, ref.Target = r, r
+		return ref, nil
+	}
+
+	for _, field := range strings.Split(r, ",") {
+		key, value, _ := strings.Cut(strings.TrimSpace(field), "=")
+
+		switch strings.ToLower(key) {
+		case "source", "src":
+			ref.Source = value
+		case "target":
+			ref.Target = value
+		case "uid":
+			ref.UID = value
+		case "gid":
+			ref.GID = value
+		case "mode":
+			mode, err := strconv.ParseUint(value, 8, 32)
+			if err != nil {
+				return ref, fmt.Errorf("invalid value of %q: %s", key, err)
+			}
+
+			ref.Mode = os.FileMode(mode)
+		default:
+			return ref, fmt.Errorf("unknown option %q", key)
+		}
+	}
+
+	if ref.Source == "" {
+		return ref, fmt.Errorf("source is required")
+	}
+
+	if ref.Target == "" {
+		ref.Target = ref.Source
+	}
+
+	return ref, nil
+}
+
+func (r fileReference) file() *swarm.SecretReferenceFileTarget {
+	return &swarm.SecretReferenceFileTarget{
+		Name: r.Target,
+		UID:  r.UID,
+		GID:  r.GID,
+		Mode: r.Mode,
+	}
+}
@@ -0,0 +1,97 @@
+package core
+
+import (
+	docker "github.com/fsouza/go-dockerclient"
+	. "gopkg.in/check.v1"
+)
+
+type SuiteOptions struct{}
+
+var _ = Suite(&SuiteOptions{})
+
+func (s *SuiteOptions) TestParseLabels(c *C) {
+	c.Assert(parseLabels(nil), IsNil)
+	c.Assert(parseLabels([]string{"foo=bar=baz", "qux"}), DeepEquals, map[string]string{
+		"foo": "bar=baz",
+		"qux": "",
+	})
+}
+
+func (s *SuiteOptions) TestParseMemory(c *C) {
+	m, err := parseMemory("")
+	c.Assert(err, IsNil)
+	c.Assert(m, Equals, int64(0))
+
+	m, err = parseMemory("512m")
+	c.Assert(err, IsNil)
+	c.Assert(m, Equals, int64(512*1024*1024))
+
+	_, err = parseMemory("lots")
+	c.Assert(err, ErrorMatches, `invalid memory "lots": .*`)
+}
+
+func (s *SuiteOptions) TestParseCPUs(c *C) {
+	n, err := parseCPUs("1.5")
+	c.Assert(err, IsNil)
+	c.Assert(n, Equals, int64(1500000000))
+
+	_, err = parseCPUs("-1")
+	c.Assert(err, ErrorMatches, `invalid cpus "-1"`)
+}
+
+func (s *SuiteOptions) TestParseDevices(c *C) {
+	d, err := parseDevices([]string{"/dev/fuse", "/dev/sda:/dev/xvda:r"})
+	c.Assert(err, IsNil)
+	c.Assert(d, DeepEquals, []docker.Device{
+		{PathOnHost: "/dev/fuse", PathInContainer: "/dev/fuse", CgroupPermissions: "rwm"},
+		{PathOnHost: "/dev/sda", PathInContainer: "/dev/xvda", CgroupPermissions: "r"},
+	})
+
+	_, err = parseDevices([]string{":/dev/xvda"})
+	c.Assert(err, ErrorMatches, `invalid device ":/dev/xvda"`)
+}
+
+func (s *SuiteOptions) TestParseTmpfs(c *C) {
+	c.Assert(parseTmpfs([]string{"/run:rw,size=64m", "/tmp"}), DeepEquals, map[string]string{
+		"/run": "rw,size=64m",
+		"/tmp": "",
+	})
+}
+
+func (s *SuiteOptions) TestParseMounts(c *C) {
+	m, err := parseMounts([]string{
+		"source=backups,target=/backups,readonly",
+		"type=bind,src=/srv,dst=/srv,bind-propagation=rslave,ro=false",
+		"type=tmpfs,target=/cache,tmpfs-size=64m,tmpfs-mode=1770",
+	})
+	c.Assert(err, IsNil)
+	c.Assert(m, DeepEquals, []docker.HostMount{
+		{Type: "volume", Source: "backups", Target: "/backups", ReadOnly: true},
+		{Type: "bind", Source: "/srv", Target: "/srv", BindOptions: &docker.BindOptions{Propagation: "rslave"}},
+		{Type: "tmpfs", Target: "/cache", TempfsOptions: &docker.TempfsOptions{SizeBytes: 64 * 1024 * 1024, Mode: 01770}},
+	})
+
+	_, err = parseMounts([]string{"source=backups"})
+	c.Assert(err, ErrorMatches, `invalid mount "source=backups": target is required`)
+
+	_, err = parseMounts([]string{"target=/data,foo=bar"})
+	c.Assert(err, ErrorMatches, `invalid mount "target=/data,foo=bar": unknown option "foo"`)
+}
+
+func (s *SuiteOptions) TestParseFileReferences(c *C) {
+	r, err := parseFileReferences([]string{
+		"db-password",
+		"source=tls-key,target=/certs/key.pem,uid=1000,mode=0400",
+	})
+	c.Assert(err, IsNil)
+	c.Assert(r, DeepEquals, []fileReference{
+		{Source: "db-password", Target: "db-password", UID: "0", GID: "0", Mode: 0444},
+		{Source: "tls-key", Target: "/certs/key.pem", UID: "1000", GID: "0", Mode: 0400},
+	})
+
+	_, err = parseFileReferences([]string{"target=/foo"})
+	c.Assert(err, ErrorMatches, `invalid reference "target=/foo": source is required`)
+
+	_, err = parseFileReferences([]string{"source=foo,mode=rw"})
+	c.Assert(err, ErrorMatches, `invalid reference "source=foo,mode=rw": invalid value of "mode": .*`)
+}
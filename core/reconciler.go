@@ -0,0 +1,313 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	docker "github.com/fsouza/go-dockerclient"
+)
+
+// Labels of the containers and services created by the jobs, used to find the
+// ones left behind when chadburn stops in the middle of an execution
+const (
+	LabelJobName     = "chadburn.job-name"
+	LabelExecutionID = "chadburn.execution-id"
+	LabelInstanceID  = "chadburn.instance-id"
+	// LabelDelete is false when the job keeps its containers on purpose
+	LabelDelete = "chadburn.delete"
+)
+
+// Orphan policies, what the reconciler does with the leftovers
+const (
+	OrphanPolicyRemove = "remove"
+	OrphanPolicyAdopt  = "adopt"
+	OrphanPolicyKeep   = "keep"
+)
+
+// DefaultInstanceID identifies the instances that do not set an ID. They all
+// share it, so the daemon of chadburn does not reconcile their leftovers.
+const DefaultInstanceID = "chadburn"
+
+var instance struct {
+	sync.RWMutex
+	id string
+}
+
+var executions struct {
+	sync.Mutex
+	running map[string]bool
+}
+
+// SetInstanceID sets the identifier of this chadburn instance, only the
+// leftovers of the same instance are reconciled. Defaults to DefaultInstanceID.
+func SetInstanceID(id string) {
+	instance.Lock()
+	defer instance.Unlock()
+	instance.id = id
+}
+
+// InstanceID returns the identifier of this chadburn instance
+func InstanceID() string {
+	instance.RLock()
+	id := instance.id
+	instance.RUnlock()
+
+	if id != "" {
+		return id
+	}
+
+	return DefaultInstanceID
+}
+
+// resourceLabels returns the labels to add to a container or service created
+// by an execution of a job
+func resourceLabels(labels map[string]string, job string, e *Execution, delete bool) map[string]string {
+	if labels == nil {
+		labels = make(map[string]string)
+	}
+
+	labels[LabelJobName] = job
+	labels[LabelExecutionID] = e.ID
+	labels[LabelInstanceID] = InstanceID()
+	labels[LabelDelete] = strconv.FormatBool(delete)
+
+	return labels
+}
+
+func trackExecution(id string, running bool) {
+	executions.Lock()
+	defer executions.Unlock()
+
+	if executions.running == nil {
+		executions.running = make(map[string]bool)
+	}
+
+	if running {
+		executions.running[id] = true
+	} else {
+		delete(executions.running, id)
+	}
+}
+
+func isExecutionRunning(id string) bool {
+	executions.Lock()
+	defer executions.Unlock()
+	return executions.running[id]
+}
+
+// Reconciler finds the containers and services created by executions of this
+// instance that are not running anymore and were not deleted, e.g. because
+// chadburn crashed, and handles them according to the policy: remove deletes
+// them right away, even if still running, adopt, the default, waits for them
+// to finish and deletes them, logging their result, and keep only reports
+// them.
+type Reconciler struct {
+	Client ContainerRuntime
+	Policy string
+	Logger Logger
+
+	// resources already reported, so they are logged only once
+	reported map[string]bool
+}
+
+func NewReconciler(c ContainerRuntime, policy string, l Logger) (*Reconciler, error) {
+	switch policy {
+	case "", OrphanPolicyRemove, OrphanPolicyAdopt, OrphanPolicyKeep:
+	default:
+		return nil, fmt.Errorf("invalid orphan policy %q", policy)
+	}
+
+	return &Reconciler{Client: c, Policy: policy, Logger: l, reported: make(map[string]bool)}, nil
+}
+
+// Watch reconciles right away and then every interval, it never returns
+func (r *Reconciler) Watch(interval time.Duration) {
+	for {
+		if err := r.Reconcile(); err != nil {
+			r.Logger.Errorf("Failed to reconcile leftovers: %s", err)
+		}
+
+		time.Sleep(interval)
+	}
+}
+
+// Reconcile handles the leftovers once
+func (r *Reconciler) Reconcile() error {
+	if err := r.reconcileContainers(); err != nil {
+		return err
+	}
+
+	return r.reconcileServices()
+}
+
+func (r *Reconciler) filters() map[string][]string {
+	return map[string][]string{
+		"label": {LabelInstanceID + "=" + InstanceID()},
+	}
+}
+
+// isOrphan returns true if the resource was left behind by an execution
+func isOrphan(labels map[string]string) bool {
+	if labels[LabelInstanceID] != InstanceID() || labels[LabelDelete] == "false" {
+		return false
+	}
+
+	return !isExecutionRunning(labels[LabelExecutionID])
+}
+
+func (r *Reconciler) reconcileContainers() error {
+	containers, err := r.Client.ListContainers(docker.ListContainersOptions{
+		All:     true,
+		Filters: r.filters(),
+	})
+	if err != nil {
+		return fmt.Errorf("error listing containers: %s", err)
+	}
+
+	for _, c := range containers {
+		if !isOrphan(c.Labels) {
+			continue
+		}
+
+		stopped := c.State != "running" && c.State != "restarting"
+		if !r.shouldRemove(c.ID, "container", c.Labels, stopped) {
+			continue
+		}
+
+		if r.policy() == OrphanPolicyAdopt {
+			r.logContainerResult(c)
+		}
+
+		err := r.Client.RemoveContainer(docker.RemoveContainerOptions{ID: c.ID, Force: true})
+		if err != nil {
+			if _, ok := err.(*docker.NoSuchContainer); !ok {
+				r.Logger.Errorf("Failed to remove leftover container %s: %s", c.ID, err)
+			}
+
+			continue
+		}
+
+		delete(r.reported, c.ID)
+	}
+
+	return nil
+}
+
+func (r *Reconciler) logContainerResult(c docker.APIContainers) {
+	container, err := r.Client.InspectContainer(c.ID)
+	if err != nil {
+		r.Logger.Warningf("Failed to inspect leftover container %s: %s", c.ID, err)
+		return
+	}
+
+	r.Logger.Noticef(
+		"Leftover container %s of job %q (execution %s) finished with exit code %d",
+		c.ID, c.Labels[LabelJobName], c.Labels[LabelExecutionID], container.State.ExitCode,
+	)
+}
+
+func (r *Reconciler) reconcileServices() error {
+	services, err := r.Client.ListServices(docker.ListServicesOptions{
+		Filters: r.filters(),
+	})
+	if err != nil {
+		// nodes that are not swarm managers can not have services
+		var dockerErr *docker.Error
+		if errors.As(err, &dockerErr) &&
+			(dockerErr.Status == http.StatusServiceUnavailable || dockerErr.Status == http.StatusNotAcceptable) {
+			return nil
+		}
+
+		return fmt.Errorf("error listing services: %s", err)
+	}
+
+	for _, svc := range services {
+		labels := svc.Spec.Labels
+		if !isOrphan(labels) {
+			continue
+		}
+
+		stopped, failed := r.serviceState(svc.ID)
+		if !r.shouldRemove(svc.ID, "service", labels, stopped) {
+			continue
+		}
+
+		if r.policy() == OrphanPolicyAdopt {
+			r.Logger.Noticef(
+				"Leftover service %s of job %q (execution %s) finished with %d failed tasks",
+				svc.ID, labels[LabelJobName], labels[LabelExecutionID], failed,
+			)
+		}
+
+		err := r.Client.RemoveService(docker.RemoveServiceOptions{ID: svc.ID})
+		if err != nil {
+			if _, ok := err.(*docker.NoSuchService); !ok {
+				r.Logger.Errorf("Failed to remove leftover service %s: %s", svc.ID, err)
+			}
+
+			continue
+		}
+
+		delete(r.reported, svc.ID)
+	}
+
+	return nil
+}
+
+// serviceState returns if all the tasks of the service have stopped and how
+// many of them have failed
+func (r *Reconciler) serviceState(svcID string) (bool, int) {
+	tasks, err := r.Client.ListTasks(docker.ListTasksOptions{
+		Filters: map[string][]string{"service": {svcID}},
+	})
+	if err != nil {
+		r.Logger.Warningf("Failed to list tasks of leftover service %s: %s", svcID, err)
+		return false, 0
+	}
+
+	var failed int
+	for i := range tasks {
+		if !taskStopped(&tasks[i]) {
+			return false, 0
+		}
+
+		if taskError(&tasks[i]) != nil {
+			failed++
+		}
+	}
+
+	return true, failed
+}
+
+// shouldRemove reports the leftover the first time it is found and returns
+// true if it has to be removed now according to the policy
+func (r *Reconciler) shouldRemove(id, kind string, labels map[string]string, stopped bool) bool {
+	if !r.reported[id] {
+		r.reported[id] = true
+		r.Logger.Warningf(
+			"Found leftover %s %s of job %q (execution %s), policy: %s",
+			kind, id, labels[LabelJobName], labels[LabelExecutionID], r.policy(),
+		)
+	}
+
+	switch r.policy() {
+	case OrphanPolicyRemove:
+		return true
+	case OrphanPolicyAdopt:
+		return stopped
+	}
+
+	return false
+}
+
+func (r *Reconciler) policy() string {
+	if r.Policy == "" {
+		return OrphanPolicyAdopt
+	}
+
+	return r.Policy
+}
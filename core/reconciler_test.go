@@ -0,0 +1,171 @@
+package core
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/docker/docker/api/types/swarm"
+	docker "github.com/fsouza/go-dockerclient"
+	"github.com/fsouza/go-dockerclient/testing"
+	logging "github.com/op/go-logging"
+	. "gopkg.in/check.v1"
+)
+
+type SuiteReconciler struct {
+	server *testing.DockerServer
+	client *docker.Client
+	logger Logger
+}
+
+var _ = Suite(&SuiteReconciler{})
+
+func (s *SuiteReconciler) SetUpTest(c *C) {
+	var err error
+	s.server, err = testing.NewServer("127.0.0.1:0", nil, nil)
+	c.Assert(err, IsNil)
+
+	s.client, err = docker.NewClient(s.server.URL())
+	c.Assert(err, IsNil)
+
+	err = s.client.PullImage(docker.PullImageOptions{Repository: "busybox"}, docker.AuthConfiguration{})
+	c.Assert(err, IsNil)
+
+	s.logger = logging.MustGetLogger("chadburn")
+	SetInstanceID("test-instance")
+}
+
+func (s *SuiteReconciler) TearDownTest(c *C) {
+	SetInstanceID("")
+}
+
+func (s *SuiteReconciler) TestNewReconcilerInvalidPolicy(c *C) {
+	_, err := NewReconciler(s.client, "delete", s.logger)
+	c.Assert(err, ErrorMatches, `invalid orphan policy "delete"`)
+}
+
+func (s *SuiteReconciler) TestReconcileContainers(c *C) {
+	running := NewExecution()
+	trackExecution(running.ID, true)
+	defer trackExecution(running.ID, false)
+
+	testcases := []struct {
+		Policy  string
+		Removed []string
+	}{
+		{OrphanPolicyRemove, []string{"orphan-running", "orphan-exited"}},
+		{OrphanPolicyAdopt, []string{"orphan-exited"}},
+		{OrphanPolicyKeep, nil},
+	}
+
+	for _, t := range testcases {
+		var containers []docker.APIContainers
+		add := func(name, state string, labels map[string]string) {
+			container, err := s.client.CreateContainer(docker.CreateContainerOptions{
+				Name:   name + "-" + t.Policy,
+				Config: &docker.Config{Image: "busybox"},
+			})
+			c.Assert(err, IsNil)
+
+			containers = append(containers, docker.APIContainers{ID: container.ID, State: state, Labels: labels})
+		}
+
+		gone := NewExecution()
+		add("orphan-running", "running", resourceLabels(nil, "foo", gone, true))
+		add("orphan-exited", "exited", resourceLabels(nil, "foo", gone, true))
+		add("kept", "exited", resourceLabels(nil, "foo", gone, false))
+		add("in-progress", "running", resourceLabels(nil, "foo", running, true))
+
+		other := resourceLabels(nil, "foo", gone, true)
+		other[LabelInstanceID] = "other-instance"
+		add("other-instance", "exited", other)
+
+		// the fake server does not return the labels of the containers
+		s.server.CustomHandler("/containers/json", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			json.NewEncoder(w).Encode(containers)
+		}))
+
+		r, err := NewReconciler(s.client, t.Policy, s.logger)
+		c.Assert(err, IsNil)
+		c.Assert(r.Reconcile(), IsNil)
+
+		var removed []string
+		for _, name := range []string{"orphan-running", "orphan-exited", "kept", "in-progress", "other-instance"} {
+			if _, err := s.client.InspectContainer(name + "-" + t.Policy); err != nil {
+				removed = append(removed, name)
+			}
+		}
+
+		c.Assert(removed, DeepEquals, t.Removed, Commentf("policy %s", t.Policy))
+	}
+}
+
+func (s *SuiteReconciler) TestReconcileDefaultInstanceID(c *C) {
+	SetInstanceID("")
+	c.Assert(InstanceID(), Equals, DefaultInstanceID)
+
+	// the leftover of a previous process without ID, which labeled it with
+	// the default one
+	container, err := s.client.CreateContainer(docker.CreateContainerOptions{
+		Name:   "orphan",
+		Config: &docker.Config{Image: "busybox"},
+	})
+	c.Assert(err, IsNil)
+
+	labels := map[string]string{
+		LabelJobName:     "foo",
+		LabelExecutionID: NewExecution().ID,
+		LabelInstanceID:  DefaultInstanceID,
+		LabelDelete:      "true",
+	}
+	s.server.CustomHandler("/containers/json", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode([]docker.APIContainers{{ID: container.ID, State: "exited", Labels: labels}})
+	}))
+
+	r, err := NewReconciler(s.client, OrphanPolicyRemove, s.logger)
+	c.Assert(err, IsNil)
+	c.Assert(r.Reconcile(), IsNil)
+
+	_, err = s.client.InspectContainer("orphan")
+	c.Assert(err, NotNil)
+}
+
+func (s *SuiteReconciler) TestReconcileServicesAdopt(c *C) {
+	// services are only available in swarm mode, which needs a newer API
+	s.server.CustomHandler("/version", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{"ApiVersion": "1.41"})
+	}))
+
+	var err error
+	s.client, err = docker.NewClient(s.server.URL())
+	c.Assert(err, IsNil)
+
+	_, err = s.client.InitSwarm(docker.InitSwarmOptions{})
+	c.Assert(err, IsNil)
+
+	state := swarm.TaskStateRunning
+	s.server.CustomHandler("/tasks", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode([]swarm.Task{{Status: swarm.TaskStatus{State: state}}})
+	}))
+
+	spec := swarm.ServiceSpec{}
+	spec.Labels = resourceLabels(nil, "foo", NewExecution(), true)
+	spec.TaskTemplate.ContainerSpec = &swarm.ContainerSpec{Image: "busybox"}
+
+	_, err = s.client.CreateService(docker.CreateServiceOptions{ServiceSpec: spec})
+	c.Assert(err, IsNil)
+
+	r, err := NewReconciler(s.client, OrphanPolicyAdopt, s.logger)
+	c.Assert(err, IsNil)
+
+	// the task is still running, the service is adopted until it finishes
+	c.Assert(r.Reconcile(), IsNil)
+	services, err := s.client.ListServices(docker.ListServicesOptions{})
+	c.Assert(err, IsNil)
+	c.Assert(services, HasLen, 1)
+
+	state = swarm.TaskStateComplete
+	c.Assert(r.Reconcile(), IsNil)
+	services, err = s.client.ListServices(docker.ListServicesOptions{})
+	c.Assert(err, IsNil)
+	c.Assert(services, HasLen, 0)
+}
@@ -0,0 +1,204 @@
+package core
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+
+	docker "github.com/fsouza/go-dockerclient"
+)
+
+// dockerHubRegistry is the name of the default registry, images without
+// registry, such as `alpine`, are pulled from it
+const dockerHubRegistry = "docker.io"
+
+// dockerHubServerAddress is the address used by docker to store and look up
+// the credentials of the default registry
+const dockerHubServerAddress = "https://index.docker.io/v1/"
+
+// helperTokenUsername is the username returned by credential helpers when the
+// secret is an identity token instead of a password
+const helperTokenUsername = "<token>"
+
+// RegistryConfig contains the credentials of a registry configured in
+// chadburn, they take precedence over the ones of the docker config files
+type RegistryConfig struct {
+	Username string
+	Password string
+	// PasswordFile is read on every pull, so the password can be rotated
+	// without reloading the configuration
+	PasswordFile  string `gcfg:"password-file" mapstructure:"password-file"`
+	IdentityToken string `gcfg:"identity-token" mapstructure:"identity-token"`
+	// CredentialHelper is the name of a docker credential helper, e.g.
+	// `ecr-login` for `docker-credential-ecr-login`
+	CredentialHelper string `gcfg:"credential-helper" mapstructure:"credential-helper"`
+}
+
+// Validate checks that the credentials of the registry come from a single
+// source, the password or its file, or the credential helper
+func (c *RegistryConfig) Validate() error {
+	if c.Password != "" && c.PasswordFile != "" {
+		return errors.New("password and password-file can not be used together")
+	}
+
+	if c.CredentialHelper != "" && (c.Username != "" || c.Password != "" || c.PasswordFile != "" || c.IdentityToken != "") {
+		return errors.New("credential-helper can not be used with username, password, password-file or identity-token")
+	}
+
+	return nil
+}
+
+var registries struct {
+	sync.RWMutex
+	configs map[string]*RegistryConfig
+}
+
+// SetRegistries replaces the credentials of the registries configured in
+// chadburn, the keys are the registry hosts, e.g. `ghcr.io`
+func SetRegistries(configs map[string]*RegistryConfig) {
+	normalized := make(map[string]*RegistryConfig, len(configs))
+	for name, c := range configs {
+		normalized[normalizeRegistry(name)] = c
+	}
+
+	registries.Lock()
+	defer registries.Unlock()
+	registries.configs = normalized
+}
+
+// buildAuthConfiguration returns the credentials of the registry, looking
+// first at the registries configured in chadburn and then at the docker
+// config files and their credential helpers. The files are read on every
+// call, so changes are picked up without restarting chadburn.
+func buildAuthConfiguration(registry string) (docker.AuthConfiguration, error) {
+	registry = normalizeRegistry(registry)
+
+	registries.RLock()
+	c, ok := registries.configs[registry]
+	registries.RUnlock()
+	if ok {
+		auth, err := c.authConfiguration(registry)
+		if err != nil {
+			return auth, fmt.Errorf("error reading credentials of registry %q: %s", registry, err)
+		}
+
+		return auth, nil
+	}
+
+	return dockerCfgAuthConfiguration(registry), nil
+}
+
+func (c *RegistryConfig) authConfiguration(registry string) (docker.AuthConfiguration, error) {
+	auth := docker.AuthConfiguration{
+		Username:      c.Username,
+		Password:      c.Password,
+		IdentityToken: c.IdentityToken,
+		ServerAddress: serverAddress(registry),
+	}
+
+	if c.CredentialHelper != "" {
+		return runCredentialHelper(c.CredentialHelper, auth.ServerAddress)
+	}
+
+	if c.PasswordFile != "" {
+		password, err := os.ReadFile(c.PasswordFile)
+		if err != nil {
+			return auth, err
+		}
+
+		auth.Password = strings.TrimSpace(string(password))
+	}
+
+	return auth, nil
+}
+
+// dockerCfgAuthConfiguration returns the credentials of the registry stored in
+// the docker config files, the empty configuration if there are none
+func dockerCfgAuthConfiguration(registry string) docker.AuthConfiguration {
+	address := serverAddress(registry)
+	if cfg, err := docker.NewAuthConfigurationsFromDockerCfg(); err == nil {
+		for _, key := range []string{registry, address, "https://" + registry} {
+			if v, ok := cfg.Configs[key]; ok {
+				return v
+			}
+		}
+
+		// try to fetch configs from docker hub default registry urls
+		// see example here: https://www.projectatomic.io/blog/2016/03/docker-credentials-store/
+		if registry == dockerHubRegistry {
+			if v, ok := cfg.Configs["https://index.docker.io/v2/"]; ok {
+				return v
+			}
+		}
+	}
+
+	// credsStore and credHelpers of the docker config files
+	if auth, err := docker.NewAuthConfigurationsFromCredsHelpers(address); err == nil {
+		auth.ServerAddress = address
+		return *auth
+	}
+
+	return docker.AuthConfiguration{}
+}
+
+// runCredentialHelper gets the credentials of the server from a docker
+// credential helper, see https://github.com/docker/docker-credential-helpers
+func runCredentialHelper(helper, server string) (docker.AuthConfiguration, error) {
+	auth := docker.AuthConfiguration{ServerAddress: server}
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command("docker-credential-"+helper, "get")
+	cmd.Stdin = strings.NewReader(server)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return auth, fmt.Errorf("credential helper %q: %s %s", helper, err, strings.TrimSpace(stderr.String()+stdout.String()))
+	}
+
+	var creds struct {
+		Username string
+		Secret   string
+	}
+
+	if err := json.Unmarshal(stdout.Bytes(), &creds); err != nil {
+		return auth, fmt.Errorf("credential helper %q: %s", helper, err)
+	}
+
+	if creds.Username == helperTokenUsername {
+		auth.IdentityToken = creds.Secret
+	} else {
+		auth.Username, auth.Password = creds.Username, creds.Secret
+	}
+
+	return auth, nil
+}
+
+// normalizeRegistry returns the host of the registry, without scheme or path,
+// and the name of the default registry for its aliases
+func normalizeRegistry(registry string) string {
+	registry = strings.TrimPrefix(registry, "https://")
+	registry = strings.TrimPrefix(registry, "http://")
+	registry, _, _ = strings.Cut(registry, "/")
+
+	switch registry {
+	case "", "index.docker.io", "registry-1.docker.io", "registry.hub.docker.com":
+		return dockerHubRegistry
+	}
+
+	return registry
+}
+
+// serverAddress returns the address of the registry used as key by docker
+// config files and credential helpers
+func serverAddress(registry string) string {
+	if registry == dockerHubRegistry {
+		return dockerHubServerAddress
+	}
+
+	return registry
+}
@@ -0,0 +1,144 @@
+package core
+
+import (
+	"encoding/base64"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	docker "github.com/fsouza/go-dockerclient"
+	. "gopkg.in/check.v1"
+)
+
+type SuiteRegistry struct{}
+
+var _ = Suite(&SuiteRegistry{})
+
+func (s *SuiteRegistry) TearDownTest(c *C) {
+	SetRegistries(nil)
+}
+
+func (s *SuiteRegistry) TestBuildAuthConfiguration(c *C) {
+	passwordFile := filepath.Join(c.MkDir(), "password")
+	err := os.WriteFile(passwordFile, []byte("secret\n"), 0600)
+	c.Assert(err, IsNil)
+
+	SetRegistries(map[string]*RegistryConfig{
+		"ghcr.io":                 {Username: "foo", PasswordFile: passwordFile},
+		"https://index.docker.io": {Username: "bar", Password: "baz"},
+		"quay.io":                 {IdentityToken: "token"},
+	})
+
+	auth, err := buildAuthConfiguration("ghcr.io")
+	c.Assert(err, IsNil)
+	c.Assert(auth, DeepEquals, docker.AuthConfiguration{
+		Username:      "foo",
+		Password:      "secret",
+		ServerAddress: "ghcr.io",
+	})
+
+	// the password file is read on every pull
+	err = os.WriteFile(passwordFile, []byte("rotated"), 0600)
+	c.Assert(err, IsNil)
+	auth, err = buildAuthConfiguration("ghcr.io")
+	c.Assert(err, IsNil)
+	c.Assert(auth.Password, Equals, "rotated")
+
+	auth, err = buildAuthConfiguration("")
+	c.Assert(err, IsNil)
+	c.Assert(auth, DeepEquals, docker.AuthConfiguration{
+		Username:      "bar",
+		Password:      "baz",
+		ServerAddress: "https://index.docker.io/v1/",
+	})
+
+	auth, err = buildAuthConfiguration("quay.io")
+	c.Assert(err, IsNil)
+	c.Assert(auth.IdentityToken, Equals, "token")
+
+	os.Remove(passwordFile)
+	_, err = buildAuthConfiguration("ghcr.io")
+	c.Assert(err, ErrorMatches, `error reading credentials of registry "ghcr.io": .*`)
+}
+
+func (s *SuiteRegistry) TestBuildAuthConfigurationHelper(c *C) {
+	dir := c.MkDir()
+	helper := filepath.Join(dir, "docker-credential-test")
+	err := os.WriteFile(helper, []byte(`#!/bin/sh
+read server
+echo "{\"ServerURL\":\"$server\",\"Username\":\"<token>\",\"Secret\":\"token-$server\"}"
+`), 0700)
+	c.Assert(err, IsNil)
+
+	defer os.Setenv("PATH", os.Getenv("PATH"))
+	os.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	SetRegistries(map[string]*RegistryConfig{
+		"ghcr.io": {CredentialHelper: "test"},
+		"quay.io": {CredentialHelper: "missing"},
+	})
+
+	auth, err := buildAuthConfiguration("ghcr.io")
+	c.Assert(err, IsNil)
+	c.Assert(auth, DeepEquals, docker.AuthConfiguration{
+		IdentityToken: "token-ghcr.io",
+		ServerAddress: "ghcr.io",
+	})
+
+	_, err = buildAuthConfiguration("quay.io")
+	c.Assert(err, ErrorMatches, `error reading credentials of registry "quay.io": credential helper "missing": .*`)
+}
+
+func (s *SuiteRegistry) TestRegistryConfigValidate(c *C) {
+	c.Assert((&RegistryConfig{Username: "foo", PasswordFile: "/run/secrets/ghcr"}).Validate(), IsNil)
+	c.Assert((&RegistryConfig{CredentialHelper: "ecr-login"}).Validate(), IsNil)
+
+	err := (&RegistryConfig{Password: "bar", PasswordFile: "/run/secrets/ghcr"}).Validate()
+	c.Assert(err, ErrorMatches, "password and password-file can not be used together")
+
+	for _, config := range []*RegistryConfig{
+		{CredentialHelper: "ecr-login", Username: "foo"},
+		{CredentialHelper: "ecr-login", Password: "bar"},
+		{CredentialHelper: "ecr-login", PasswordFile: "/run/secrets/ghcr"},
+		{CredentialHelper: "ecr-login", IdentityToken: "bar"},
+	} {
+		c.Assert(config.Validate(), ErrorMatches, "credential-helper can not be used with .*")
+	}
+}
+
+func (s *SuiteRegistry) TestBuildAuthConfigurationDockerCfg(c *C) {
+	dir := c.MkDir()
+	defer os.Setenv("DOCKER_CONFIG", os.Getenv("DOCKER_CONFIG"))
+	os.Setenv("DOCKER_CONFIG", dir)
+
+	writeConfig := func(password string) {
+		auth := base64.StdEncoding.EncodeToString([]byte("foo:" + password))
+		config := fmt.Sprintf(`{"auths": {"ghcr.io": {"auth": %q}}}`, auth)
+		err := os.WriteFile(filepath.Join(dir, "config.json"), []byte(config), 0600)
+		c.Assert(err, IsNil)
+	}
+
+	writeConfig("bar")
+	auth, err := buildAuthConfiguration("ghcr.io")
+	c.Assert(err, IsNil)
+	c.Assert(auth.Username, Equals, "foo")
+	c.Assert(auth.Password, Equals, "bar")
+
+	// the docker config is read on every pull
+	writeConfig("baz")
+	auth, err = buildAuthConfiguration("ghcr.io")
+	c.Assert(err, IsNil)
+	c.Assert(auth.Password, Equals, "baz")
+
+	auth, err = buildAuthConfiguration("quay.io")
+	c.Assert(err, IsNil)
+	c.Assert(auth, DeepEquals, docker.AuthConfiguration{})
+}
+
+func (s *SuiteRegistry) TestNormalizeRegistry(c *C) {
+	c.Assert(normalizeRegistry(""), Equals, "docker.io")
+	c.Assert(normalizeRegistry("https://index.docker.io/v1/"), Equals, "docker.io")
+	c.Assert(normalizeRegistry("registry-1.docker.io"), Equals, "docker.io")
+	c.Assert(normalizeRegistry("https://ghcr.io"), Equals, "ghcr.io")
+	c.Assert(normalizeRegistry("example.com:5000"), Equals, "example.com:5000")
+}
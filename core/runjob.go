@@ -1,93 +1,106 @@
 package core
 
 import (
+	"context"
 	"fmt"
 	"reflect"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	docker "github.com/fsouza/go-dockerclient"
 	"github.com/gobs/args"
 )
 
-var dockercfg *docker.AuthConfigurations
-
-func init() {
-	dockercfg, _ = docker.NewAuthConfigurationsFromDockerCfg()
-}
-
 type RunJob struct {
 	BareJob `mapstructure:",squash"`
-	Client  *docker.Client `json:"-"`
-	User    string         `default:"root"`
+	Client  ContainerRuntime `json:"-"`
+	User    string           `default:"root" hash:"true"`
 
-	TTY bool `default:"false"`
+	TTY bool `default:"false" hash:"true"`
 
 	// do not use bool values with "default:true" because if
 	// user would set it to "false" explicitly, it still will be
 	// changed to "true" https://github.com/mcuadros/ofelia/issues/135
 	// so lets use strings here as workaround
-	Delete string `default:"true"`
-	Pull   string `default:"true"`
-
-	Image     string
-	Network   string
-	Container string
-	Volume    []string
+	Delete string `default:"true" hash:"true"`
+	Pull   string `default:"true" hash:"true"`
+	// PullPolicy takes precedence over Pull, one of always, if-not-present,
+	// never or interval:<duration>
+	PullPolicy string `gcfg:"pull-policy" mapstructure:"pull-policy" hash:"true"`
+
+	// DockerHost is the name of a `docker-host` section, the daemon of the
+	// environment is used if empty
+	DockerHost string `gcfg:"docker-host" mapstructure:"docker-host" hash:"true"`
+
+	// Template renders the command, the volumes and the environment variables
+	// as templates on every execution, see TemplateData
+	Template bool `default:"false" hash:"true"`
+
+	Image     string   `hash:"true"`
+	Network   string   `hash:"true"`
+	Container string   `hash:"true"`
+	Volume    []string `hash:"true"`
+	// Mount holds mounts in the format of `docker run --mount`, so named
+	// volumes can be used, e.g. `type=volume,source=backups,target=/backups`
+	Mount []string `hash:"true"`
+
+	// Environment variables in `KEY=value` form, they take precedence over
+	// the ones read from EnvFile, which is read on every execution
+	Environment []string `hash:"true"`
+	EnvFile     string   `gcfg:"env-file" mapstructure:"env-file" hash:"true"`
+	Entrypoint  string   `hash:"true"`
+	WorkingDir  string   `gcfg:"workdir" mapstructure:"workdir" hash:"true"`
+	Hostname    string   `hash:"true"`
+	Label       []string `hash:"true"`
+
+	Memory      string   `hash:"true"`
+	CPUs        string   `hash:"true"`
+	CapAdd      []string `gcfg:"cap-add" mapstructure:"cap-add" hash:"true"`
+	CapDrop     []string `gcfg:"cap-drop" mapstructure:"cap-drop" hash:"true"`
+	Device      []string `hash:"true"`
+	Tmpfs       []string `hash:"true"`
+	AddHost     []string `gcfg:"add-host" mapstructure:"add-host" hash:"true"`
+	DNS         []string `hash:"true"`
+	SecurityOpt []string `gcfg:"security-opt" mapstructure:"security-opt" hash:"true"`
+	ReadOnly    bool     `gcfg:"read-only" mapstructure:"read-only" default:"false" hash:"true"`
+
+	mu         sync.Mutex
+	lastPull   time.Time
+	lastDigest string
 }
 
-func NewRunJob(c *docker.Client) *RunJob {
+// Image pull policies of job-run
+const (
+	PullPolicyAlways       = "always"
+	PullPolicyIfNotPresent = "if-not-present"
+	PullPolicyNever        = "never"
+	PullPolicyInterval     = "interval"
+)
+
+func NewRunJob(c ContainerRuntime) *RunJob {
 	return &RunJob{Client: c}
 }
 
-func (j *RunJob) Run(ctx *Context) error {
-	var container *docker.Container
-	var err error
-	pull, _ := strconv.ParseBool(j.Pull)
-
-	if j.Image != "" && j.Container == "" {
-		if err = func() error {
-			var pullError error
-
-			// if Pull option "true"
-			// try pulling image first
-			if pull {
-				if pullError = j.pullImage(); pullError == nil {
-					ctx.Log("Pulled image " + j.Image)
-					return nil
-				}
-			}
-
-			// if Pull option "false"
-			// try to find image locally first
-			searchErr := j.searchLocalImage()
-			if searchErr == nil {
-				ctx.Log("Found locally image " + j.Image)
-				return nil
-			}
-
-			// if couldn't find image locally, still try to pull
-			if !pull && searchErr == ErrLocalImageNotFound {
-				if pullError = j.pullImage(); pullError == nil {
-					ctx.Log("Pulled image " + j.Image)
-					return nil
-				}
-			}
+func (j *RunJob) Run(ctx *Context) (err error) {
+	if j.Client == nil {
+		return unknownDockerHost(j.DockerHost)
+	}
 
-			if pullError != nil {
-				return pullError
-			}
+	if err := checkDocker(j.Client); err != nil {
+		return err
+	}
 
-			if searchErr != nil {
-				return searchErr
-			}
+	defer func() { err = dockerError(j.Client, err) }()
 
-			return nil
-		}(); err != nil {
+	var container *docker.Container
+	if j.Image != "" && j.Container == "" {
+		if err = j.ensureImage(ctx); err != nil {
 			return err
 		}
 
-		container, err = j.buildContainer()
+		container, err = j.buildContainer(ctx.Execution)
 		if err != nil {
 			return err
 		}
@@ -98,24 +111,25 @@ func (j *RunJob) Run(ctx *Context) error {
 		}
 	}
 
-	startTime := time.Now()
-	if err := j.startContainer(ctx.Execution, container); err != nil {
+	image := j.Image
+	if image == "" {
+		image = container.Image
+	}
+
+	j.trackDigest(ctx, image)
+
+	// attach before starting, so no output of the container is lost
+	attach, err := j.attachContainer(ctx.Execution, container.ID)
+	if err != nil {
 		return err
 	}
 
-	if err := j.watchContainer(container.ID); err != nil {
+	if err := j.startContainer(ctx.Execution, container); err != nil {
+		attach.Close()
 		return err
 	}
 
-	if err := j.Client.Logs(docker.LogsOptions{
-		Container:    container.ID,
-		OutputStream: ctx.Execution.OutputStream,
-		ErrorStream:  ctx.Execution.ErrorStream,
-		Stdout:       true,
-		Stderr:       true,
-		Since:        startTime.Unix(),
-		RawTerminal:  true,
-	}); err != nil {
+	if err := j.watchContainer(container.ID, attach); err != nil {
 		return err
 	}
 
@@ -125,6 +139,125 @@ func (j *RunJob) Run(ctx *Context) error {
 	return nil
 }
 
+// ensureImage makes the image available according to the pull policy
+func (j *RunJob) ensureImage(ctx *Context) error {
+	policy, interval, err := j.pullPolicy()
+	if err != nil {
+		return err
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	pull := policy == PullPolicyAlways ||
+		(policy == PullPolicyInterval && time.Since(j.lastPull) >= interval)
+
+	var pullErr error
+	if pull {
+		if pullErr = j.pullImage(); pullErr == nil {
+			j.lastPull = time.Now()
+			ctx.Log("Pulled image " + j.Image)
+			return nil
+		}
+
+		// a registry outage does not stop the job if the image is available
+		ctx.Log(fmt.Sprintf("Falling back to local image: %s", pullErr))
+	}
+
+	searchErr := j.searchLocalImage()
+	if searchErr == nil {
+		ctx.Log("Found locally image " + j.Image)
+		return nil
+	}
+
+	if pullErr != nil {
+		return pullErr
+	}
+
+	if searchErr != ErrLocalImageNotFound || policy == PullPolicyNever {
+		return searchErr
+	}
+
+	if err := j.pullImage(); err != nil {
+		return err
+	}
+
+	j.lastPull = time.Now()
+	ctx.Log("Pulled image " + j.Image)
+	return nil
+}
+
+// pullPolicy returns the pull policy of the job, and the interval of the
+// interval policy. Without policy the legacy Pull option is used, "true"
+// always pulls and "false" pulls only if the image is not present.
+func (j *RunJob) pullPolicy() (string, time.Duration, error) {
+	policy := j.PullPolicy
+	if policy == "" {
+		if pull, _ := strconv.ParseBool(j.Pull); pull {
+			return PullPolicyAlways, 0, nil
+		}
+
+		return PullPolicyIfNotPresent, 0, nil
+	}
+
+	switch policy {
+	case PullPolicyAlways, PullPolicyIfNotPresent, PullPolicyNever:
+		return policy, 0, nil
+	}
+
+	if value, ok := strings.CutPrefix(policy, PullPolicyInterval+":"); ok {
+		interval, err := time.ParseDuration(value)
+		if err == nil && interval > 0 {
+			return PullPolicyInterval, interval, nil
+		}
+	}
+
+	return "", 0, fmt.Errorf("invalid pull policy %q", policy)
+}
+
+// trackDigest records the digest of the image on the execution, along with
+// the previous one if it changed since the last execution of the job
+func (j *RunJob) trackDigest(ctx *Context, image string) {
+	digest, err := j.imageDigest(image)
+	if err != nil {
+		ctx.Log(fmt.Sprintf("Unable to get digest of image %q: %s", image, err))
+		return
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	ctx.Execution.ImageDigest = digest
+	if j.lastDigest != "" && j.lastDigest != digest {
+		ctx.Execution.PreviousImageDigest = j.lastDigest
+		ctx.Log(fmt.Sprintf("Image %q changed from %s to %s", image, j.lastDigest, digest))
+	}
+
+	j.lastDigest = digest
+}
+
+// imageDigest returns the repository digest of the image, e.g.
+// `alpine@sha256:...`, or its ID for images not pulled from a registry
+func (j *RunJob) imageDigest(image string) (string, error) {
+	img, err := j.Client.InspectImage(image)
+	if err != nil {
+		return "", err
+	}
+
+	repository, _ := docker.ParseRepositoryTag(image)
+	for _, d := range img.RepoDigests {
+		if strings.HasPrefix(d, repository+"@") {
+			return d, nil
+		}
+	}
+
+	if len(img.RepoDigests) > 0 {
+		return img.RepoDigests[0], nil
+	}
+
+	return img.ID, nil
+}
+
 func (j *RunJob) searchLocalImage() error {
 	imgs, err := j.Client.ListImages(buildFindLocalImageOptions(j.Image))
 	if err != nil {
@@ -139,7 +272,11 @@ func (j *RunJob) searchLocalImage() error {
 }
 
 func (j *RunJob) pullImage() error {
-	o, a := buildPullOptions(j.Image)
+	o, a, err := buildPullOptions(j.Image)
+	if err != nil {
+		return err
+	}
+
 	if err := j.Client.PullImage(o, a); err != nil {
 		return fmt.Errorf("error pulling image %q: %s", j.Image, err)
 	}
@@ -147,21 +284,20 @@ func (j *RunJob) pullImage() error {
 	return nil
 }
 
-func (j *RunJob) buildContainer() (*docker.Container, error) {
+func (j *RunJob) buildContainer(e *Execution) (*docker.Container, error) {
+	config, hostConfig, err := j.buildContainerConfig(e)
+	if err != nil {
+		return nil, err
+	}
+
+	delete, _ := strconv.ParseBool(j.Delete)
+	config.Labels = resourceLabels(config.Labels, j.Name, e, delete)
+	config.Env = mergeEnvironment(config.Env, executionEnvironment(j, e))
+
 	c, err := j.Client.CreateContainer(docker.CreateContainerOptions{
-		Config: &docker.Config{
-			Image:        j.Image,
-			AttachStdin:  false,
-			AttachStdout: true,
-			AttachStderr: true,
-			Tty:          j.TTY,
-			Cmd:          args.GetArgs(j.Command),
-			User:         j.User,
-		},
+		Config:           config,
 		NetworkingConfig: &docker.NetworkingConfig{},
-		HostConfig: &docker.HostConfig{
-			Binds: j.Volume,
-		},
+		HostConfig:       hostConfig,
 	})
 
 	if err != nil {
@@ -186,6 +322,110 @@ func (j *RunJob) buildContainer() (*docker.Container, error) {
 	return c, nil
 }
 
+func (j *RunJob) buildContainerConfig(e *Execution) (*docker.Config, *docker.HostConfig, error) {
+	data := newTemplateData(j, e, j.Template)
+	command, err := renderTemplate(j.Command, data)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	volumes, err := renderTemplates(j.Volume, data)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	environment, err := renderTemplates(j.Environment, data)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	env, err := buildEnvironment(j.EnvFile, environment)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	memory, err := parseMemory(j.Memory)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	cpus, err := parseCPUs(j.CPUs)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	devices, err := parseDevices(j.Device)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	mounts, err := parseMounts(j.Mount)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var entrypoint []string
+	if j.Entrypoint != "" {
+		entrypoint = args.GetArgs(j.Entrypoint)
+	}
+
+	config := &docker.Config{
+		Image:        j.Image,
+		AttachStdin:  false,
+		AttachStdout: true,
+		AttachStderr: true,
+		Tty:          j.TTY,
+		Cmd:          args.GetArgs(command),
+		User:         j.User,
+		Env:          env,
+		Entrypoint:   entrypoint,
+		WorkingDir:   j.WorkingDir,
+		Hostname:     j.Hostname,
+		Labels:       parseLabels(j.Label),
+	}
+
+	hostConfig := &docker.HostConfig{
+		Binds:          volumes,
+		Mounts:         mounts,
+		Memory:         memory,
+		NanoCPUs:       cpus,
+		CapAdd:         j.CapAdd,
+		CapDrop:        j.CapDrop,
+		Devices:        devices,
+		Tmpfs:          parseTmpfs(j.Tmpfs),
+		ExtraHosts:     j.AddHost,
+		DNS:            j.DNS,
+		SecurityOpt:    j.SecurityOpt,
+		ReadonlyRootfs: j.ReadOnly,
+	}
+
+	return config, hostConfig, nil
+}
+
+func (j *RunJob) attachContainer(e *Execution, containerID string) (docker.CloseWaiter, error) {
+	success := make(chan struct{})
+	attach, err := j.Client.AttachToContainerNonBlocking(docker.AttachToContainerOptions{
+		Container:    containerID,
+		OutputStream: e.OutputStream,
+		ErrorStream:  e.ErrorStream,
+		Stream:       true,
+		Stdout:       true,
+		Stderr:       true,
+		// without a tty stdout and stderr are multiplexed in the same stream
+		RawTerminal: j.TTY,
+		Success:     success,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error attaching to container: %s", err)
+	}
+
+	// wait for the connection to be established
+	<-success
+	success <- struct{}{}
+
+	return attach, nil
+}
+
 func (j *RunJob) startContainer(e *Execution, c *docker.Container) error {
 	return j.Client.StartContainer(c.ID, &docker.HostConfig{})
 }
@@ -203,35 +443,32 @@ const (
 	maxProcessDuration = time.Hour * 24
 )
 
-func (j *RunJob) watchContainer(containerID string) error {
-	var s docker.State
-	var r time.Duration
-	for {
-		time.Sleep(watchDuration)
-		r += watchDuration
+func (j *RunJob) watchContainer(containerID string, attach docker.CloseWaiter) error {
+	ctx, cancel := context.WithTimeout(context.Background(), maxProcessDuration)
+	defer cancel()
 
-		if r > maxProcessDuration {
+	exitCode, err := j.Client.WaitContainerWithContext(containerID, ctx)
+	if err != nil {
+		attach.Close()
+		if ctx.Err() == context.DeadlineExceeded {
 			return ErrMaxTimeRunning
 		}
 
-		c, err := j.Client.InspectContainer(containerID)
-		if err != nil {
-			return err
-		}
+		return fmt.Errorf("error waiting for container: %s", err)
+	}
 
-		if !c.State.Running {
-			s = c.State
-			break
-		}
+	// the attach ends along with the container, wait for the whole output
+	if err := attach.Wait(); err != nil {
+		return fmt.Errorf("error reading container output: %s", err)
 	}
 
-	switch s.ExitCode {
+	switch exitCode {
 	case 0:
 		return nil
 	case -1:
 		return ErrUnexpected
 	default:
-		return fmt.Errorf("error non-zero exit code: %d", s.ExitCode)
+		return fmt.Errorf("error non-zero exit code: %d", exitCode)
 	}
 }
 
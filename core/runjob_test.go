@@ -3,6 +3,8 @@ package core
 import (
 	"archive/tar"
 	"bytes"
+	"encoding/json"
+	"net/http"
 	"sync"
 	"time"
 
@@ -76,22 +78,237 @@ func (s *SuiteRunJob) TestRun(c *C) {
 	c.Assert(containers, HasLen, 0)
 }
 
+func (s *SuiteRunJob) TestRunOutput(c *C) {
+	job := &RunJob{Client: s.client}
+	job.Image = ImageFixture
+	job.Command = "echo foo"
+	job.Delete = "true"
+	job.Name = "test"
+
+	ctx := &Context{}
+	ctx.Execution = NewExecution()
+	ctx.Logger = logging.MustGetLogger("chadburn")
+	ctx.Job = job
+
+	go func() {
+		time.Sleep(time.Millisecond * 200)
+
+		containers, err := s.client.ListContainers(docker.ListContainersOptions{})
+		c.Assert(err, IsNil)
+
+		err = s.server.MutateContainer(containers[0].ID, docker.State{ExitCode: 2, StartedAt: time.Now()})
+		c.Assert(err, IsNil)
+	}()
+
+	err := job.Run(ctx)
+	c.Assert(err, ErrorMatches, "error non-zero exit code: 2")
+
+	// the output written by the fake server on attach, demultiplexed
+	c.Assert(ctx.Execution.OutputStream.String(), Equals, "Container is not running\nWhat happened?\nSomething happened\n")
+	c.Assert(ctx.Execution.ErrorStream.String(), Equals, "")
+}
+
+func (s *SuiteRunJob) TestPullPolicy(c *C) {
+	job := &RunJob{}
+	testcases := []struct {
+		Pull, PullPolicy string
+		Policy           string
+		Interval         time.Duration
+	}{
+		{Pull: "true", Policy: PullPolicyAlways},
+		{Pull: "false", Policy: PullPolicyIfNotPresent},
+		{Pull: "true", PullPolicy: "never", Policy: PullPolicyNever},
+		{PullPolicy: "if-not-present", Policy: PullPolicyIfNotPresent},
+		{PullPolicy: "interval:6h", Policy: PullPolicyInterval, Interval: 6 * time.Hour},
+	}
+
+	for _, t := range testcases {
+		job.Pull, job.PullPolicy = t.Pull, t.PullPolicy
+		policy, interval, err := job.pullPolicy()
+		c.Assert(err, IsNil)
+		c.Assert(policy, Equals, t.Policy)
+		c.Assert(interval, Equals, t.Interval)
+	}
+
+	for _, p := range []string{"sometimes", "interval:", "interval:-1h"} {
+		job.PullPolicy = p
+		_, _, err := job.pullPolicy()
+		c.Assert(err, ErrorMatches, "invalid pull policy .*")
+	}
+}
+
+func (s *SuiteRunJob) TestEnsureImage(c *C) {
+	// the fake server ignores the reference filter when listing images
+	missing := true
+	s.server.CustomHandler("/images/json", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		images := []docker.APIImages{{ID: "foo"}}
+		if missing {
+			images = nil
+		}
+
+		json.NewEncoder(w).Encode(images)
+	}))
+
+	job := &RunJob{Client: s.client}
+	job.Image = ImageFixture
+	job.PullPolicy = PullPolicyNever
+
+	ctx := &Context{Execution: NewExecution(), Logger: &TestLogger{}, Job: job}
+	c.Assert(job.ensureImage(ctx), Equals, ErrLocalImageNotFound)
+	c.Assert(job.lastPull.IsZero(), Equals, true)
+
+	missing = false
+	job.PullPolicy = "interval:1h"
+	c.Assert(job.ensureImage(ctx), IsNil)
+	lastPull := job.lastPull
+	c.Assert(lastPull.IsZero(), Equals, false)
+
+	// within the interval the local image is used
+	c.Assert(job.ensureImage(ctx), IsNil)
+	c.Assert(job.lastPull, Equals, lastPull)
+}
+
+func (s *SuiteRunJob) TestTrackDigest(c *C) {
+	job := &RunJob{Client: s.client}
+	ctx := &Context{Execution: NewExecution(), Logger: &TestLogger{}, Job: job}
+	job.trackDigest(ctx, ImageFixture)
+
+	digest := ctx.Execution.ImageDigest
+	c.Assert(digest, Not(Equals), "")
+	c.Assert(ctx.Execution.ImageChanged(), Equals, false)
+
+	ctx.Execution = NewExecution()
+	job.trackDigest(ctx, ImageFixture)
+	c.Assert(ctx.Execution.ImageDigest, Equals, digest)
+	c.Assert(ctx.Execution.ImageChanged(), Equals, false)
+
+	// the tag now points to a new image
+	s.buildImage(c)
+	ctx.Execution = NewExecution()
+	job.trackDigest(ctx, ImageFixture)
+	c.Assert(ctx.Execution.ImageDigest, Not(Equals), digest)
+	c.Assert(ctx.Execution.PreviousImageDigest, Equals, digest)
+	c.Assert(ctx.Execution.ImageChanged(), Equals, true)
+}
+
+func (s *SuiteRunJob) TestBuildPullImageOptionsDigest(c *C) {
+	o, _, _ := buildPullOptions("quay.io/srcd/rest:qux@sha256:abc")
+	c.Assert(o.Repository, Equals, "quay.io/srcd/rest")
+	c.Assert(o.Tag, Equals, "sha256:abc")
+	c.Assert(o.Registry, Equals, "quay.io")
+
+	f := buildFindLocalImageOptions("quay.io/srcd/rest:qux@sha256:abc")
+	c.Assert(f.Filters["reference"], DeepEquals, []string{"quay.io/srcd/rest@sha256:abc"})
+}
+
+func (s *SuiteRunJob) TestHash(c *C) {
+	// the options of the container are part of the hash, so editing any of
+	// them reschedules the job on reload instead of keeping the stale one
+	edits := map[string]func(j *RunJob){
+		"user":      func(j *RunJob) { j.User = "nobody" },
+		"tty":       func(j *RunJob) { j.TTY = true },
+		"delete":    func(j *RunJob) { j.Delete = "false" },
+		"pull":      func(j *RunJob) { j.Pull = "false" },
+		"image":     func(j *RunJob) { j.Image = "postgres:16" },
+		"network":   func(j *RunJob) { j.Network = "backend" },
+		"container": func(j *RunJob) { j.Container = "backup" },
+		"volume":    func(j *RunJob) { j.Volume = []string{"/srv:/srv"} },
+		"memory":    func(j *RunJob) { j.Memory = "1g" },
+	}
+
+	newJob := func() *RunJob {
+		job := &RunJob{User: "root", Delete: "true", Pull: "true", Image: "postgres:15"}
+		job.Name = "backup"
+		job.Schedule = "@daily"
+		return job
+	}
+
+	hash := newJob().Hash()
+	c.Assert(newJob().Hash(), Equals, hash)
+	for name, edit := range edits {
+		job := newJob()
+		edit(job)
+		c.Assert(job.Hash(), Not(Equals), hash, Commentf("editing %s", name))
+	}
+}
+
+func (s *SuiteRunJob) TestBuildContainerOptions(c *C) {
+	job := &RunJob{Client: s.client}
+	job.Image = ImageFixture
+	job.Command = "backup --all"
+	job.Entrypoint = "/bin/sh -c"
+	job.Environment = []string{"FOO=bar"}
+	job.WorkingDir = "/backups"
+	job.Hostname = "backup"
+	job.Label = []string{"com.example.team=ops"}
+	job.Volume = []string{"/srv:/srv:ro"}
+	job.Mount = []string{"source=backups,target=/backups"}
+	job.Memory = "1g"
+	job.CPUs = "0.5"
+	job.CapAdd = []string{"SYS_ADMIN"}
+	job.CapDrop = []string{"NET_RAW"}
+	job.Device = []string{"/dev/fuse"}
+	job.Tmpfs = []string{"/run:size=64m"}
+	job.AddHost = []string{"db:10.0.0.2"}
+	job.DNS = []string{"10.0.0.1"}
+	job.SecurityOpt = []string{"no-new-privileges"}
+	job.ReadOnly = true
+
+	job.Name = "backup"
+	e := NewExecution()
+	container, err := job.buildContainer(e)
+	c.Assert(err, IsNil)
+
+	container, err = s.client.InspectContainer(container.ID)
+	c.Assert(err, IsNil)
+	c.Assert(container.Config.Cmd, DeepEquals, []string{"backup", "--all"})
+	c.Assert(container.Config.Entrypoint, DeepEquals, []string{"/bin/sh", "-c"})
+	c.Assert(container.Config.Env, DeepEquals, append([]string{"FOO=bar"}, executionEnvironment(job, e)...))
+	assertExecutionVars(c, container.Config.Env)
+	c.Assert(container.Config.WorkingDir, Equals, "/backups")
+	c.Assert(container.Config.Labels, DeepEquals, map[string]string{
+		"com.example.team": "ops",
+		LabelJobName:       "backup",
+		LabelExecutionID:   e.ID,
+		LabelInstanceID:    InstanceID(),
+		LabelDelete:        "false",
+	})
+
+	host := container.HostConfig
+	c.Assert(host.Binds, DeepEquals, []string{"/srv:/srv:ro"})
+	c.Assert(host.Mounts, DeepEquals, []docker.HostMount{{Type: "volume", Source: "backups", Target: "/backups"}})
+	c.Assert(host.Memory, Equals, int64(1024*1024*1024))
+	c.Assert(host.NanoCPUs, Equals, int64(500000000))
+	c.Assert(host.CapAdd, DeepEquals, []string{"SYS_ADMIN"})
+	c.Assert(host.CapDrop, DeepEquals, []string{"NET_RAW"})
+	c.Assert(host.Devices, HasLen, 1)
+	c.Assert(host.Tmpfs, DeepEquals, map[string]string{"/run": "size=64m"})
+	c.Assert(host.ExtraHosts, DeepEquals, []string{"db:10.0.0.2"})
+	c.Assert(host.DNS, DeepEquals, []string{"10.0.0.1"})
+	c.Assert(host.SecurityOpt, DeepEquals, []string{"no-new-privileges"})
+	c.Assert(host.ReadonlyRootfs, Equals, true)
+
+	job.Memory = "lots"
+	_, err = job.buildContainer(NewExecution())
+	c.Assert(err, ErrorMatches, `invalid memory "lots": .*`)
+}
+
 func (s *SuiteRunJob) TestBuildPullImageOptionsBareImage(c *C) {
-	o, _ := buildPullOptions("foo")
+	o, _, _ := buildPullOptions("foo")
 	c.Assert(o.Repository, Equals, "foo")
 	c.Assert(o.Tag, Equals, "latest")
 	c.Assert(o.Registry, Equals, "")
 }
 
 func (s *SuiteRunJob) TestBuildPullImageOptionsVersion(c *C) {
-	o, _ := buildPullOptions("foo:qux")
+	o, _, _ := buildPullOptions("foo:qux")
 	c.Assert(o.Repository, Equals, "foo")
 	c.Assert(o.Tag, Equals, "qux")
 	c.Assert(o.Registry, Equals, "")
 }
 
 func (s *SuiteRunJob) TestBuildPullImageOptionsRegistry(c *C) {
-	o, _ := buildPullOptions("quay.io/srcd/rest:qux")
+	o, _, _ := buildPullOptions("quay.io/srcd/rest:qux")
 	c.Assert(o.Repository, Equals, "quay.io/srcd/rest")
 	c.Assert(o.Tag, Equals, "qux")
 	c.Assert(o.Registry, Equals, "quay.io")
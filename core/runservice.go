@@ -3,41 +3,95 @@ package core
 import (
 	"fmt"
 	"reflect"
+	"sort"
 	"strconv"
-	"strings"
-	"sync"
 	"time"
 
 	"github.com/docker/docker/api/types/swarm"
 	docker "github.com/fsouza/go-dockerclient"
+	"github.com/gobs/args"
 )
 
 // Note: The ServiceJob is loosely inspired by https://github.com/alexellis/jaas/
 
 type RunServiceJob struct {
 	BareJob `mapstructure:",squash"`
-	Client  *docker.Client `json:"-"`
-	User    string         `default:"root"`
-	TTY     bool           `default:"false"`
+	Client  ContainerRuntime `json:"-"`
+	User    string           `default:"root" hash:"true"`
+	TTY     bool             `default:"false" hash:"true"`
 	// do not use bool values with "default:true" because if
 	// user would set it to "false" explicitly, it still will be
 	// changed to "true" https://github.com/mcuadros/ofelia/issues/135
 	// so lets use strings here as workaround
-	Delete  string `default:"true"`
-	Image   string
-	Network string
+	Delete  string `default:"true" hash:"true"`
+	Image   string `hash:"true"`
+	Network string `hash:"true"`
+	// DockerHost is the name of a `docker-host` section, the daemon of the
+	// environment is used if empty
+	DockerHost string `gcfg:"docker-host" mapstructure:"docker-host" hash:"true"`
+
+	// Mode is one of replicated, a single task, replicated-job, running
+	// TotalCompletions tasks, at most MaxConcurrent at the same time, or
+	// global-job, running a task on every node meeting the constraints
+	Mode             string `default:"replicated" hash:"true"`
+	MaxConcurrent    int    `gcfg:"max-concurrent" mapstructure:"max-concurrent" hash:"true"`
+	TotalCompletions int    `gcfg:"total-completions" mapstructure:"total-completions" hash:"true"`
+
+	// Template renders the command and the environment variables as
+	// templates on every execution, see TemplateData
+	Template bool `default:"false" hash:"true"`
+	// Environment variables in `KEY=value` form, they take precedence over
+	// the ones read from EnvFile, which is read on every execution
+	Environment []string `hash:"true"`
+	EnvFile     string   `gcfg:"env-file" mapstructure:"env-file" hash:"true"`
+	WorkingDir  string   `gcfg:"workdir" mapstructure:"workdir" hash:"true"`
+	// Mount holds mounts in the format of `docker service create --mount`
+	Mount []string `hash:"true"`
+
+	Memory            string `hash:"true"`
+	CPUs              string `hash:"true"`
+	MemoryReservation string `gcfg:"memory-reservation" mapstructure:"memory-reservation" hash:"true"`
+	CPUsReservation   string `gcfg:"cpus-reservation" mapstructure:"cpus-reservation" hash:"true"`
+
+	// Constraint holds placement constraints, e.g. `node.labels.role==batch`
+	Constraint []string `hash:"true"`
+	// Secret and Config hold the names of existing secrets and configs, or
+	// references in the format of `docker service create --secret`
+	Secret []string `hash:"true"`
+	Config []string `hash:"true"`
 }
 
-func NewRunServiceJob(c *docker.Client) *RunServiceJob {
+// Service modes of job-service-run
+const (
+	ServiceModeReplicated    = "replicated"
+	ServiceModeReplicatedJob = "replicated-job"
+	ServiceModeGlobalJob     = "global-job"
+)
+
+// jobSettleDuration is how long a job whose tasks have stopped, before all
+// of them are scheduled, waits for swarm to schedule the missing ones
+const jobSettleDuration = 3 * time.Second
+
+func NewRunServiceJob(c ContainerRuntime) *RunServiceJob {
 	return &RunServiceJob{Client: c}
 }
 
-func (j *RunServiceJob) Run(ctx *Context) error {
+func (j *RunServiceJob) Run(ctx *Context) (err error) {
+	if j.Client == nil {
+		return unknownDockerHost(j.DockerHost)
+	}
+
+	if err := checkDocker(j.Client); err != nil {
+		return err
+	}
+
+	defer func() { err = dockerError(j.Client, err) }()
+
 	if err := j.pullImage(); err != nil {
 		return err
 	}
 
-	svc, err := j.buildService()
+	svc, err := j.buildService(ctx.Execution)
 
 	if err != nil {
 		return err
@@ -45,15 +99,21 @@ func (j *RunServiceJob) Run(ctx *Context) error {
 
 	ctx.Logger.Noticef("Created service %s for job %s\n", svc.ID, j.Name)
 
-	if err := j.watchContainer(ctx, svc.ID); err != nil {
-		return err
+	// the service is removed whatever the outcome of its task
+	err = j.watchContainer(ctx, svc.ID)
+	if deleteErr := j.deleteService(ctx, svc.ID); err == nil {
+		err = deleteErr
 	}
 
-	return j.deleteService(ctx, svc.ID)
+	return err
 }
 
 func (j *RunServiceJob) pullImage() error {
-	o, a := buildPullOptions(j.Image)
+	o, a, err := buildPullOptions(j.Image)
+	if err != nil {
+		return err
+	}
+
 	if err := j.Client.PullImage(o, a); err != nil {
 		return fmt.Errorf("error pulling image %q: %s", j.Image, err)
 	}
@@ -61,142 +121,537 @@ func (j *RunServiceJob) pullImage() error {
 	return nil
 }
 
-func (j *RunServiceJob) buildService() (*swarm.Service, error) {
+func (j *RunServiceJob) buildService(e *Execution) (*swarm.Service, error) {
+	spec, err := j.buildServiceSpec(e)
+	if err != nil {
+		return nil, err
+	}
 
-	//createOptions := types.ServiceCreateOptions{}
+	delete, _ := strconv.ParseBool(j.Delete)
+	spec.Labels = resourceLabels(spec.Labels, j.Name, e, delete)
+	container := spec.TaskTemplate.ContainerSpec
+	container.Env = mergeEnvironment(container.Env, executionEnvironment(j, e))
 
-	max := uint64(1)
-	createSvcOpts := docker.CreateServiceOptions{}
+	// the credentials are sent along, so the swarm nodes can pull the image
+	_, auth, err := buildPullOptions(j.Image)
+	if err != nil {
+		return nil, err
+	}
 
-	createSvcOpts.ServiceSpec.TaskTemplate.ContainerSpec =
-		&swarm.ContainerSpec{
-			Image: j.Image,
-		}
+	svc, err := j.Client.CreateService(docker.CreateServiceOptions{
+		Auth:        auth,
+		ServiceSpec: *spec,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return svc, err
+}
+
+func (j *RunServiceJob) buildServiceSpec(e *Execution) (*swarm.ServiceSpec, error) {
+	data := newTemplateData(j, e, j.Template)
+	command, err := renderTemplate(j.Command, data)
+	if err != nil {
+		return nil, err
+	}
+
+	environment, err := renderTemplates(j.Environment, data)
+	if err != nil {
+		return nil, err
+	}
+
+	env, err := buildEnvironment(j.EnvFile, environment)
+	if err != nil {
+		return nil, err
+	}
+
+	mounts, err := parseServiceMounts(j.Mount)
+	if err != nil {
+		return nil, err
+	}
+
+	resources, err := j.buildResources()
+	if err != nil {
+		return nil, err
+	}
+
+	secrets, err := j.buildSecrets()
+	if err != nil {
+		return nil, err
+	}
+
+	configs, err := j.buildConfigs()
+	if err != nil {
+		return nil, err
+	}
+
+	mode, err := j.buildServiceMode()
+	if err != nil {
+		return nil, err
+	}
+
+	spec := &swarm.ServiceSpec{Mode: mode}
+	spec.TaskTemplate.ContainerSpec = &swarm.ContainerSpec{
+		Image:   j.Image,
+		User:    j.User,
+		TTY:     j.TTY,
+		Env:     env,
+		Dir:     j.WorkingDir,
+		Mounts:  mounts,
+		Secrets: secrets,
+		Configs: configs,
+	}
+
+	if command != "" {
+		spec.TaskTemplate.ContainerSpec.Command = args.GetArgs(command)
+	}
 
 	// Make the service run once and not restart
-	createSvcOpts.ServiceSpec.TaskTemplate.RestartPolicy =
-		&swarm.RestartPolicy{
-			MaxAttempts: &max,
-			Condition:   swarm.RestartPolicyConditionNone,
-		}
+	max := uint64(1)
+	spec.TaskTemplate.RestartPolicy = &swarm.RestartPolicy{
+		MaxAttempts: &max,
+		Condition:   swarm.RestartPolicyConditionNone,
+	}
+
+	spec.TaskTemplate.Resources = resources
+
+	if len(j.Constraint) != 0 {
+		spec.TaskTemplate.Placement = &swarm.Placement{Constraints: j.Constraint}
+	}
 
 	// For a service to interact with other services in a stack,
 	// we need to attach it to the same network
 	if j.Network != "" {
-		createSvcOpts.Networks = []swarm.NetworkAttachmentConfig{
+		spec.Networks = []swarm.NetworkAttachmentConfig{
 			swarm.NetworkAttachmentConfig{
 				Target: j.Network,
 			},
 		}
 	}
 
-	if j.Command != "" {
-		createSvcOpts.ServiceSpec.TaskTemplate.ContainerSpec.Command = strings.Split(j.Command, " ")
+	return spec, nil
+}
+
+func (j *RunServiceJob) buildServiceMode() (swarm.ServiceMode, error) {
+	if j.MaxConcurrent < 0 || j.TotalCompletions < 0 {
+		return swarm.ServiceMode{}, fmt.Errorf("invalid max-concurrent %d or total-completions %d", j.MaxConcurrent, j.TotalCompletions)
+	}
+
+	switch j.Mode {
+	case "", ServiceModeReplicated:
+		// the default mode of docker, a single replica
+		return swarm.ServiceMode{}, nil
+	case ServiceModeReplicatedJob:
+		job := &swarm.ReplicatedJob{}
+		if j.MaxConcurrent > 0 {
+			n := uint64(j.MaxConcurrent)
+			job.MaxConcurrent = &n
+		}
+
+		if j.TotalCompletions > 0 {
+			n := uint64(j.TotalCompletions)
+			job.TotalCompletions = &n
+		}
+
+		return swarm.ServiceMode{ReplicatedJob: job}, nil
+	case ServiceModeGlobalJob:
+		return swarm.ServiceMode{GlobalJob: &swarm.GlobalJob{}}, nil
 	}
 
-	svc, err := j.Client.CreateService(createSvcOpts)
+	return swarm.ServiceMode{}, fmt.Errorf("invalid mode %q", j.Mode)
+}
+
+// isJob returns true if the service runs in one of the job modes of swarm
+func (j *RunServiceJob) isJob() bool {
+	return j.Mode == ServiceModeReplicatedJob || j.Mode == ServiceModeGlobalJob
+}
+
+// totalCompletions returns the number of tasks of a replicated job, with the
+// same defaults as docker
+func (j *RunServiceJob) totalCompletions() int {
+	switch {
+	case j.TotalCompletions > 0:
+		return j.TotalCompletions
+	case j.MaxConcurrent > 0:
+		return j.MaxConcurrent
+	}
+
+	return 1
+}
+
+func (j *RunServiceJob) buildResources() (*swarm.ResourceRequirements, error) {
+	limits, err := buildResources(j.Memory, j.CPUs)
 	if err != nil {
 		return nil, err
 	}
 
-	return svc, err
+	reservations, err := buildResources(j.MemoryReservation, j.CPUsReservation)
+	if err != nil {
+		return nil, err
+	}
+
+	if limits == nil && reservations == nil {
+		return nil, nil
+	}
+
+	r := &swarm.ResourceRequirements{Reservations: reservations}
+	if limits != nil {
+		r.Limits = &swarm.Limit{NanoCPUs: limits.NanoCPUs, MemoryBytes: limits.MemoryBytes}
+	}
+
+	return r, nil
 }
 
-const (
+// buildResources returns the resources of a service, nil if none is set
+func buildResources(memory, cpus string) (*swarm.Resources, error) {
+	m, err := parseMemory(memory)
+	if err != nil {
+		return nil, err
+	}
 
-	// TODO are these const defined somewhere in the docker API?
-	swarmError   = -999
-	timeoutError = -998
-)
+	n, err := parseCPUs(cpus)
+	if err != nil {
+		return nil, err
+	}
 
-var svcChecker = time.NewTicker(watchDuration)
+	if m == 0 && n == 0 {
+		return nil, nil
+	}
 
-func (j *RunServiceJob) watchContainer(ctx *Context, svcID string) error {
-	exitCode := swarmError
+	return &swarm.Resources{NanoCPUs: n, MemoryBytes: m}, nil
+}
 
-	ctx.Logger.Noticef("Checking for service ID %s (%s) termination\n", svcID, j.Name)
+func (j *RunServiceJob) buildSecrets() ([]*swarm.SecretReference, error) {
+	refs, err := parseFileReferences(j.Secret)
+	if err != nil {
+		return nil, err
+	}
+
+	var secrets []*swarm.SecretReference
+	for _, ref := range refs {
+		list, err := j.Client.ListSecrets(docker.ListSecretsOptions{
+			Filters: map[string][]string{"name": {ref.Source}},
+		})
+		if err != nil {
+			return nil, fmt.Errorf("error listing secrets: %s", err)
+		}
+
+		// the name filter also matches names with the same prefix
+		id := ""
+		for _, s := range list {
+			if s.Spec.Name == ref.Source {
+				id = s.ID
+			}
+		}
 
-	svc, err := j.Client.InspectService(svcID)
+		if id == "" {
+			return nil, fmt.Errorf("error secret %q not found", ref.Source)
+		}
+
+		secrets = append(secrets, &swarm.SecretReference{
+			SecretID:   id,
+			SecretName: ref.Source,
+			File:       ref.file(),
+		})
+	}
+
+	return secrets, nil
+}
+
+func (j *RunServiceJob) buildConfigs() ([]*swarm.ConfigReference, error) {
+	refs, err := parseFileReferences(j.Config)
 	if err != nil {
-		return fmt.Errorf("Failed to inspect service %s: %s", svcID, err.Error())
+		return nil, err
 	}
 
-	// On every tick, check if all the services have completed, or have error out
-	var wg sync.WaitGroup
-	wg.Add(1)
+	var configs []*swarm.ConfigReference
+	for _, ref := range refs {
+		list, err := j.Client.ListConfigs(docker.ListConfigsOptions{
+			Filters: map[string][]string{"name": {ref.Source}},
+		})
+		if err != nil {
+			return nil, fmt.Errorf("error listing configs: %s", err)
+		}
 
-	go func() {
-		defer wg.Done()
-		for _ = range svcChecker.C {
+		// the name filter also matches names with the same prefix
+		id := ""
+		for _, c := range list {
+			if c.Spec.Name == ref.Source {
+				id = c.ID
+			}
+		}
+
+		if id == "" {
+			return nil, fmt.Errorf("error config %q not found", ref.Source)
+		}
+
+		configs = append(configs, &swarm.ConfigReference{
+			ConfigID:   id,
+			ConfigName: ref.Source,
+			File:       (*swarm.ConfigReferenceFileTarget)(ref.file()),
+		})
+	}
+
+	return configs, nil
+}
 
-			if svc.CreatedAt.After(time.Now().Add(maxProcessDuration)) {
-				err = ErrMaxTimeRunning
-				return
+func (j *RunServiceJob) watchContainer(ctx *Context, svcID string) error {
+	ctx.Logger.Noticef("Checking for service ID %s (%s) termination\n", svcID, j.Name)
+
+	// every run has its own ticker, so concurrent jobs do not steal the ticks
+	// of each other
+	ticker := time.NewTicker(watchDuration)
+	defer ticker.Stop()
+
+	timeout := time.NewTimer(maxProcessDuration)
+	defer timeout.Stop()
+
+	// number of stopped tasks of a replicated job that will not complete,
+	// and since when no other task has been scheduled
+	var stalled int
+	var stalledSince time.Time
+
+	for {
+		select {
+		case <-timeout.C:
+			return ErrMaxTimeRunning
+		case <-ticker.C:
+		}
+
+		if j.isJob() {
+			tasks, done := j.findJobTasks(ctx, svcID)
+			if !done {
+				continue
+			}
+
+			var settle bool
+			switch j.Mode {
+			case ServiceModeReplicatedJob:
+				// the failed tasks are not replaced, as the restart condition
+				// is none, so the completions may never be reached. The job is
+				// over once swarm has not scheduled any other task for a while.
+				if len(tasks) < j.totalCompletions() {
+					if !tasksFailed(tasks) {
+						continue
+					}
+
+					settle = true
+				}
+			case ServiceModeGlobalJob:
+				// swarm creates the tasks of a global job node by node, the
+				// job is over once there are as many as it desires, or after
+				// a while if the daemon does not report it
+				desired, known := j.desiredTasks(ctx, svcID)
+				if known && len(tasks) < desired {
+					continue
+				}
+
+				settle = !known
 			}
 
-			taskExitCode, found := j.findtaskstatus(ctx, svc.ID)
+			if settle {
+				if len(tasks) != stalled {
+					stalled, stalledSince = len(tasks), time.Now()
+					continue
+				}
 
-			if found {
-				exitCode = taskExitCode
-				return
+				if time.Since(stalledSince) < jobSettleDuration {
+					continue
+				}
 			}
+
+			j.collectLogs(ctx, svcID)
+			return j.recordTasks(ctx.Execution, tasks)
 		}
-	}()
 
-	wg.Wait()
+		task, done := j.findtaskstatus(ctx, svcID)
+		if !done {
+			continue
+		}
 
-	ctx.Logger.Noticef("Service ID %s (%s) has completed with exit code %d\n", svcID, j.Name, exitCode)
-	return err
+		if task == nil {
+			// the service is gone, maybe someone else removed it, our work
+			// here is done
+			ctx.Logger.Warningf("Service ID %s (%s) has no tasks left, its status is unknown\n", svcID, j.Name)
+			return nil
+		}
+
+		j.collectLogs(ctx, svcID)
+		return taskError(task)
+	}
 }
 
-func (j *RunServiceJob) findtaskstatus(ctx *Context, taskID string) (int, bool) {
+// findtaskstatus returns the task of the service once it has stopped, nil
+// if the service has no tasks anymore
+func (j *RunServiceJob) findtaskstatus(ctx *Context, svcID string) (*swarm.Task, bool) {
 	taskFilters := make(map[string][]string)
-	taskFilters["service"] = []string{taskID}
+	taskFilters["service"] = []string{svcID}
 
 	tasks, err := j.Client.ListTasks(docker.ListTasksOptions{
 		Filters: taskFilters,
 	})
 
 	if err != nil {
-		ctx.Logger.Errorf("Failed to find task ID %s. Considering the task terminated: %s\n", taskID, err.Error())
-		return 0, false
+		ctx.Logger.Errorf("Failed to find tasks of service ID %s: %s\n", svcID, err.Error())
+		return nil, false
 	}
 
 	if len(tasks) == 0 {
-		// That task is gone now (maybe someone else removed it. Our work here is done
-		return 0, true
+		return nil, true
 	}
 
-	exitCode := 1
-	var done bool
-	stopStates := []swarm.TaskState{
-		swarm.TaskStateComplete,
-		swarm.TaskStateFailed,
-		swarm.TaskStateRejected,
+	for i := range tasks {
+		if taskStopped(&tasks[i]) {
+			return &tasks[i], true
+		}
 	}
 
-	for _, task := range tasks {
+	return nil, false
+}
 
-		stop := false
-		for _, stopState := range stopStates {
-			if task.Status.State == stopState {
-				stop = true
-				break
-			}
+// findJobTasks returns the tasks of a service in one of the job modes once
+// all of them have stopped
+func (j *RunServiceJob) findJobTasks(ctx *Context, svcID string) ([]swarm.Task, bool) {
+	tasks, err := j.Client.ListTasks(docker.ListTasksOptions{
+		Filters: map[string][]string{"service": {svcID}},
+	})
+
+	if err != nil {
+		ctx.Logger.Errorf("Failed to find tasks of service ID %s: %s\n", svcID, err.Error())
+		return nil, false
+	}
+
+	if len(tasks) == 0 {
+		return nil, false
+	}
+
+	for i := range tasks {
+		if !taskStopped(&tasks[i]) {
+			return nil, false
 		}
+	}
 
-		if stop {
+	return tasks, true
+}
 
-			exitCode = task.Status.ContainerStatus.ExitCode
+// desiredTasks returns the number of tasks swarm desires for the service,
+// false if the daemon does not report it
+func (j *RunServiceJob) desiredTasks(ctx *Context, svcID string) (int, bool) {
+	services, err := j.Client.ListServices(docker.ListServicesOptions{
+		Filters: map[string][]string{"id": {svcID}},
+		Status:  true,
+	})
 
-			if exitCode == 0 && task.Status.State == swarm.TaskStateRejected {
-				exitCode = 255 // force non-zero exit for task rejected
-			}
-			done = true
-			break
+	if err != nil {
+		ctx.Logger.Errorf("Failed to find status of service ID %s: %s\n", svcID, err.Error())
+		return 0, false
+	}
+
+	for _, svc := range services {
+		if svc.ID == svcID && svc.ServiceStatus != nil {
+			return int(svc.ServiceStatus.DesiredTasks), true
 		}
 	}
-	return exitCode, done
+
+	return 0, false
+}
+
+// tasksFailed returns true if any of the stopped tasks has failed
+func tasksFailed(tasks []swarm.Task) bool {
+	for i := range tasks {
+		if taskError(&tasks[i]) != nil {
+			return true
+		}
+	}
+
+	return false
+}
+
+// recordTasks adds the result of every task to the execution, targets are
+// the slots of replicated jobs and the nodes of global jobs
+func (j *RunServiceJob) recordTasks(e *Execution, tasks []swarm.Task) error {
+	sort.Slice(tasks, func(a, b int) bool {
+		if tasks[a].Slot != tasks[b].Slot {
+			return tasks[a].Slot < tasks[b].Slot
+		}
+
+		return tasks[a].NodeID < tasks[b].NodeID
+	})
+
+	var failed int
+	for i := range tasks {
+		t := &tasks[i]
+
+		target := fmt.Sprintf("task %d", t.Slot)
+		if j.Mode == ServiceModeGlobalJob {
+			target = "node " + t.NodeID
+		}
+
+		var d time.Duration
+		if !t.CreatedAt.IsZero() && t.Status.Timestamp.After(t.CreatedAt) {
+			d = t.Status.Timestamp.Sub(t.CreatedAt)
+		}
+
+		err := taskError(t)
+		e.AddTarget(target, d, err)
+		if err != nil {
+			failed++
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("error %d of %d tasks failed", failed, len(tasks))
+	}
+
+	return nil
+}
+
+func taskStopped(task *swarm.Task) bool {
+	switch task.Status.State {
+	case swarm.TaskStateComplete, swarm.TaskStateFailed, swarm.TaskStateRejected:
+		return true
+	}
+
+	return false
+}
+
+// taskError returns the error of a stopped task, nil if it has completed
+// successfully
+func taskError(task *swarm.Task) error {
+	if task.Status.State == swarm.TaskStateRejected {
+		return fmt.Errorf("error task rejected: %s", task.Status.Err)
+	}
+
+	exitCode := 0
+	if task.Status.ContainerStatus != nil {
+		exitCode = task.Status.ContainerStatus.ExitCode
+	}
+
+	switch {
+	case exitCode != 0:
+		return fmt.Errorf("error non-zero exit code: %d", exitCode)
+	case task.Status.State == swarm.TaskStateFailed:
+		return fmt.Errorf("error task failed: %s", task.Status.Err)
+	}
+
+	return nil
+}
+
+// collectLogs copies the logs of the service into the execution streams, a
+// failure to get them does not fail the execution
+func (j *RunServiceJob) collectLogs(ctx *Context, svcID string) {
+	err := j.Client.GetServiceLogs(docker.LogsServiceOptions{
+		Service:      svcID,
+		OutputStream: ctx.Execution.OutputStream,
+		ErrorStream:  ctx.Execution.ErrorStream,
+		Stdout:       true,
+		Stderr:       true,
+	})
+
+	if err != nil {
+		ctx.Logger.Warningf("Failed to get logs of service ID %s (%s): %s\n", svcID, j.Name, err)
+	}
 }
 
 func (j *RunServiceJob) deleteService(ctx *Context, svcID string) error {
@@ -3,12 +3,16 @@ package core
 import (
 	"archive/tar"
 	"bytes"
+	"encoding/json"
 	"fmt"
+	"net/http"
 	"strings"
 	"sync"
 	"time"
 
+	"github.com/docker/docker/api/types/mount"
 	"github.com/docker/docker/api/types/swarm"
+	"github.com/docker/docker/pkg/stdcopy"
 	docker "github.com/fsouza/go-dockerclient"
 	"github.com/fsouza/go-dockerclient/testing"
 	logging "github.com/op/go-logging"
@@ -92,22 +96,275 @@ func (s *SuiteRunServiceJob) TestRun(c *C) {
 	c.Assert(containers, HasLen, 0)
 }
 
+func (s *SuiteRunServiceJob) TestRunTaskResult(c *C) {
+	var state swarm.TaskState
+	var exitCode int
+	s.server.CustomHandler("/tasks", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode([]swarm.Task{{
+			Status: swarm.TaskStatus{
+				State:           state,
+				Err:             "no suitable node",
+				ContainerStatus: &swarm.ContainerStatus{ExitCode: exitCode},
+			},
+		}})
+	}))
+
+	s.server.CustomHandler("/services/.*/logs", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		stdcopy.NewStdWriter(w, stdcopy.Stdout).Write([]byte("foo\n"))
+		stdcopy.NewStdWriter(w, stdcopy.Stderr).Write([]byte("bar\n"))
+	}))
+
+	job := &RunServiceJob{Client: s.client}
+	job.Image = ServiceImageFixture
+	job.Command = "echo foo"
+	job.Delete = "true"
+
+	testcases := []struct {
+		State    swarm.TaskState
+		ExitCode int
+		Error    string
+	}{
+		{swarm.TaskStateComplete, 0, ""},
+		{swarm.TaskStateFailed, 3, "error non-zero exit code: 3"},
+		{swarm.TaskStateRejected, 0, "error task rejected: no suitable node"},
+	}
+
+	for _, t := range testcases {
+		state, exitCode = t.State, t.ExitCode
+
+		e := NewExecution()
+		err := job.Run(&Context{Execution: e, Logger: logger})
+		if t.Error == "" {
+			c.Assert(err, IsNil)
+		} else {
+			c.Assert(err, ErrorMatches, t.Error)
+		}
+
+		c.Assert(e.OutputStream.String(), Equals, "foo\n")
+		c.Assert(e.ErrorStream.String(), Equals, "bar\n")
+
+		// the service is removed whatever the outcome
+		services, err := s.client.ListServices(docker.ListServicesOptions{})
+		c.Assert(err, IsNil)
+		c.Assert(services, HasLen, 0)
+	}
+}
+
+func (s *SuiteRunServiceJob) TestRunReplicatedJob(c *C) {
+	done := swarm.TaskStatus{State: swarm.TaskStateComplete, ContainerStatus: &swarm.ContainerStatus{}}
+	failed := swarm.TaskStatus{State: swarm.TaskStateFailed, ContainerStatus: &swarm.ContainerStatus{ExitCode: 2}}
+
+	var calls int
+	s.server.CustomHandler("/tasks", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+
+		// the last completion is not scheduled yet on the first call
+		tasks := []swarm.Task{{Slot: 2, Status: failed}, {Slot: 1, Status: done}}
+		if calls > 1 {
+			tasks = append(tasks, swarm.Task{Slot: 3, Status: done})
+		}
+
+		json.NewEncoder(w).Encode(tasks)
+	}))
+
+	s.server.CustomHandler("/services/.*/logs", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	job := &RunServiceJob{Client: s.client}
+	job.Image = ServiceImageFixture
+	job.Command = "echo foo"
+	job.Delete = "false"
+	job.Mode = ServiceModeReplicatedJob
+	job.MaxConcurrent = 2
+	job.TotalCompletions = 3
+
+	e := NewExecution()
+	err := job.Run(&Context{Execution: e, Logger: logger})
+	c.Assert(err, ErrorMatches, "error 1 of 3 tasks failed")
+	c.Assert(calls, Equals, 2)
+
+	c.Assert(e.Targets, HasLen, 3)
+	c.Assert(e.Targets[0].Target, Equals, "task 1")
+	c.Assert(e.Targets[0].Failed, Equals, false)
+	c.Assert(e.Targets[1].Target, Equals, "task 2")
+	c.Assert(e.Targets[1].Error, ErrorMatches, "error non-zero exit code: 2")
+	c.Assert(e.Targets[2].Target, Equals, "task 3")
+
+	services, err := s.client.ListServices(docker.ListServicesOptions{})
+	c.Assert(err, IsNil)
+	c.Assert(services, HasLen, 1)
+
+	assertExecutionVars(c, services[0].Spec.TaskTemplate.ContainerSpec.Env)
+
+	mode := services[0].Spec.Mode
+	c.Assert(mode.ReplicatedJob, NotNil)
+	c.Assert(*mode.ReplicatedJob.MaxConcurrent, Equals, uint64(2))
+	c.Assert(*mode.ReplicatedJob.TotalCompletions, Equals, uint64(3))
+}
+
+func (s *SuiteRunServiceJob) TestRunReplicatedJobFailedTask(c *C) {
+	failed := swarm.TaskStatus{State: swarm.TaskStateFailed, ContainerStatus: &swarm.ContainerStatus{ExitCode: 1}}
+
+	// the failed task is not replaced, the other completions never come
+	s.server.CustomHandler("/tasks", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode([]swarm.Task{{Slot: 1, Status: failed}})
+	}))
+
+	s.server.CustomHandler("/services/.*/logs", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	job := &RunServiceJob{Client: s.client}
+	job.Image = ServiceImageFixture
+	job.Command = "echo foo"
+	job.Delete = "true"
+	job.Mode = ServiceModeReplicatedJob
+	job.TotalCompletions = 3
+
+	e := NewExecution()
+	start := time.Now()
+	err := job.Run(&Context{Execution: e, Logger: logger})
+	c.Assert(err, ErrorMatches, "error 1 of 1 tasks failed")
+	c.Assert(time.Since(start) >= jobSettleDuration, Equals, true)
+	c.Assert(time.Since(start) < jobSettleDuration+5*time.Second, Equals, true)
+	c.Assert(e.Targets, HasLen, 1)
+	c.Assert(e.Targets[0].Error, ErrorMatches, "error non-zero exit code: 1")
+}
+
+func (s *SuiteRunServiceJob) TestRunGlobalJob(c *C) {
+	done := swarm.TaskStatus{State: swarm.TaskStateComplete, ContainerStatus: &swarm.ContainerStatus{}}
+
+	var calls int
+	s.server.CustomHandler("/tasks", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+
+		// the task of the second node is not created yet on the first call
+		tasks := []swarm.Task{{NodeID: "node-1", Status: done}}
+		if calls > 1 {
+			tasks = append(tasks, swarm.Task{NodeID: "node-2", Status: done})
+		}
+
+		json.NewEncoder(w).Encode(tasks)
+	}))
+
+	var status bool
+	s.server.CustomHandler("/services$", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		status = r.URL.Query().Get("status") == "1"
+
+		var filters map[string][]string
+		json.Unmarshal([]byte(r.URL.Query().Get("filters")), &filters)
+		json.NewEncoder(w).Encode([]swarm.Service{{
+			ID:            filters["id"][0],
+			ServiceStatus: &swarm.ServiceStatus{DesiredTasks: 2},
+		}})
+	}))
+
+	s.server.CustomHandler("/services/.*/logs", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	job := &RunServiceJob{Client: s.client}
+	job.Image = ServiceImageFixture
+	job.Command = "echo foo"
+	job.Mode = ServiceModeGlobalJob
+
+	e := NewExecution()
+	err := job.Run(&Context{Execution: e, Logger: logger})
+	c.Assert(err, IsNil)
+	c.Assert(calls, Equals, 2)
+	c.Assert(status, Equals, true)
+
+	c.Assert(e.Targets, HasLen, 2)
+	c.Assert(e.Targets[0].Target, Equals, "node node-1")
+	c.Assert(e.Targets[1].Target, Equals, "node node-2")
+}
+
+func (s *SuiteRunServiceJob) TestBuildServiceMode(c *C) {
+	job := &RunServiceJob{}
+
+	mode, err := job.buildServiceMode()
+	c.Assert(err, IsNil)
+	c.Assert(mode, DeepEquals, swarm.ServiceMode{})
+
+	job.Mode = ServiceModeGlobalJob
+	mode, err = job.buildServiceMode()
+	c.Assert(err, IsNil)
+	c.Assert(mode.GlobalJob, NotNil)
+
+	job.Mode = "global"
+	_, err = job.buildServiceMode()
+	c.Assert(err, ErrorMatches, `invalid mode "global"`)
+}
+
+func (s *SuiteRunServiceJob) TestBuildServiceSpec(c *C) {
+	s.server.CustomHandler("/secrets", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode([]swarm.Secret{
+			{ID: "secret-2", Spec: swarm.SecretSpec{Annotations: swarm.Annotations{Name: "db-password-old"}}},
+			{ID: "secret-1", Spec: swarm.SecretSpec{Annotations: swarm.Annotations{Name: "db-password"}}},
+		})
+	}))
+
+	s.server.CustomHandler("/configs", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode([]swarm.Config{
+			{ID: "config-1", Spec: swarm.ConfigSpec{Annotations: swarm.Annotations{Name: "app-config"}}},
+		})
+	}))
+
+	job := &RunServiceJob{Client: s.client}
+	job.Image = ServiceImageFixture
+	job.Command = `sh -c "echo foo bar"`
+	job.User = "foo"
+	job.TTY = true
+	job.Environment = []string{"FOO=bar"}
+	job.WorkingDir = "/app"
+	job.Mount = []string{"source=backups,target=/backups"}
+	job.Memory = "1g"
+	job.CPUs = "2"
+	job.MemoryReservation = "512m"
+	job.Constraint = []string{"node.labels.role==batch"}
+	job.Secret = []string{"db-password"}
+	job.Config = []string{"source=app-config,target=/etc/app.conf"}
+
+	spec, err := job.buildServiceSpec(NewExecution())
+	c.Assert(err, IsNil)
+
+	cs := spec.TaskTemplate.ContainerSpec
+	c.Assert(cs.Command, DeepEquals, []string{"sh", "-c", "echo foo bar"})
+	c.Assert(cs.User, Equals, "foo")
+	c.Assert(cs.TTY, Equals, true)
+	c.Assert(cs.Env, DeepEquals, []string{"FOO=bar"})
+	c.Assert(cs.Dir, Equals, "/app")
+	c.Assert(cs.Mounts, DeepEquals, []mount.Mount{{Type: mount.TypeVolume, Source: "backups", Target: "/backups"}})
+
+	c.Assert(cs.Secrets, HasLen, 1)
+	c.Assert(cs.Secrets[0].SecretID, Equals, "secret-1")
+	c.Assert(cs.Secrets[0].File.Name, Equals, "db-password")
+	c.Assert(cs.Configs, HasLen, 1)
+	c.Assert(cs.Configs[0].ConfigID, Equals, "config-1")
+	c.Assert(cs.Configs[0].File.Name, Equals, "/etc/app.conf")
+
+	c.Assert(spec.TaskTemplate.Resources, DeepEquals, &swarm.ResourceRequirements{
+		Limits:       &swarm.Limit{NanoCPUs: 2e9, MemoryBytes: 1024 * 1024 * 1024},
+		Reservations: &swarm.Resources{MemoryBytes: 512 * 1024 * 1024},
+	})
+	c.Assert(spec.TaskTemplate.Placement.Constraints, DeepEquals, []string{"node.labels.role==batch"})
+
+	job.Secret = []string{"api-token"}
+	_, err = job.buildServiceSpec(NewExecution())
+	c.Assert(err, ErrorMatches, `error secret "api-token" not found`)
+}
+
 func (s *SuiteRunServiceJob) TestBuildPullImageOptionsBareImage(c *C) {
-	o, _ := buildPullOptions("foo")
+	o, _, _ := buildPullOptions("foo")
 	c.Assert(o.Repository, Equals, "foo")
 	c.Assert(o.Tag, Equals, "latest")
 	c.Assert(o.Registry, Equals, "")
 }
 
 func (s *SuiteRunServiceJob) TestBuildPullImageOptionsVersion(c *C) {
-	o, _ := buildPullOptions("foo:qux")
+	o, _, _ := buildPullOptions("foo:qux")
 	c.Assert(o.Repository, Equals, "foo")
 	c.Assert(o.Tag, Equals, "qux")
 	c.Assert(o.Registry, Equals, "")
 }
 
 func (s *SuiteRunServiceJob) TestBuildPullImageOptionsRegistry(c *C) {
-	o, _ := buildPullOptions("quay.io/srcd/rest:qux")
+	o, _, _ := buildPullOptions("quay.io/srcd/rest:qux")
 	c.Assert(o.Repository, Equals, "quay.io/srcd/rest")
 	c.Assert(o.Tag, Equals, "qux")
 	c.Assert(o.Registry, Equals, "quay.io")
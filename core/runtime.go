@@ -0,0 +1,51 @@
+package core
+
+import (
+	"context"
+
+	"github.com/docker/docker/api/types/swarm"
+	docker "github.com/fsouza/go-dockerclient"
+)
+
+// ContainerRuntime is the subset of the docker API used by the docker jobs
+// and the reconciler. *docker.Client implements it, other implementations,
+// e.g. a client of a Podman compatible API or a fake in the tests, can be
+// given to the jobs instead. Implementations may also implement
+// HealthReporter.
+type ContainerRuntime interface {
+	Ping() error
+
+	// images
+	InspectImage(name string) (*docker.Image, error)
+	ListImages(opts docker.ListImagesOptions) ([]docker.APIImages, error)
+	PullImage(opts docker.PullImageOptions, auth docker.AuthConfiguration) error
+
+	// containers
+	CreateContainer(opts docker.CreateContainerOptions) (*docker.Container, error)
+	InspectContainer(id string) (*docker.Container, error)
+	ListContainers(opts docker.ListContainersOptions) ([]docker.APIContainers, error)
+	AttachToContainerNonBlocking(opts docker.AttachToContainerOptions) (docker.CloseWaiter, error)
+	StartContainer(id string, hostConfig *docker.HostConfig) error
+	WaitContainerWithContext(id string, ctx context.Context) (int, error)
+	RemoveContainer(opts docker.RemoveContainerOptions) error
+
+	// exec
+	CreateExec(opts docker.CreateExecOptions) (*docker.Exec, error)
+	StartExec(id string, opts docker.StartExecOptions) error
+	InspectExec(id string) (*docker.ExecInspect, error)
+
+	// networks
+	FilteredListNetworks(opts docker.NetworkFilterOpts) ([]docker.Network, error)
+	ConnectNetwork(id string, opts docker.NetworkConnectionOptions) error
+
+	// swarm
+	CreateService(opts docker.CreateServiceOptions) (*swarm.Service, error)
+	ListServices(opts docker.ListServicesOptions) ([]swarm.Service, error)
+	RemoveService(opts docker.RemoveServiceOptions) error
+	GetServiceLogs(opts docker.LogsServiceOptions) error
+	ListTasks(opts docker.ListTasksOptions) ([]swarm.Task, error)
+	ListSecrets(opts docker.ListSecretsOptions) ([]swarm.Secret, error)
+	ListConfigs(opts docker.ListConfigsOptions) ([]swarm.Config, error)
+}
+
+var _ ContainerRuntime = (*docker.Client)(nil)
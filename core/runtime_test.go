@@ -0,0 +1,203 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	docker "github.com/fsouza/go-dockerclient"
+	logging "github.com/op/go-logging"
+	. "gopkg.in/check.v1"
+)
+
+// fakeRuntime is a ContainerRuntime recording its calls, it implements what
+// RunJob and ExecJob need, any other call panics
+type fakeRuntime struct {
+	ContainerRuntime
+
+	output   string
+	exitCode int
+	calls    []string
+	created  *docker.CreateContainerOptions
+	exec     *docker.CreateExecOptions
+	// standard input of the last exec
+	input string
+	// running containers, by name, with their health status, empty for the
+	// containers without healthcheck
+	containers map[string]string
+}
+
+func (r *fakeRuntime) record(format string, a ...interface{}) {
+	r.calls = append(r.calls, fmt.Sprintf(format, a...))
+}
+
+func (r *fakeRuntime) Ping() error {
+	return nil
+}
+
+func (r *fakeRuntime) InspectImage(name string) (*docker.Image, error) {
+	r.record("inspect-image %s", name)
+	return &docker.Image{ID: "sha256:1234", RepoDigests: []string{name + "@sha256:5678"}}, nil
+}
+
+func (r *fakeRuntime) ListImages(opts docker.ListImagesOptions) ([]docker.APIImages, error) {
+	r.record("list-images")
+	return []docker.APIImages{{ID: "sha256:1234"}}, nil
+}
+
+func (r *fakeRuntime) CreateContainer(opts docker.CreateContainerOptions) (*docker.Container, error) {
+	r.record("create-container %s", opts.Config.Image)
+	r.created = &opts
+	return &docker.Container{ID: "c1", Image: opts.Config.Image}, nil
+}
+
+func (r *fakeRuntime) AttachToContainerNonBlocking(opts docker.AttachToContainerOptions) (docker.CloseWaiter, error) {
+	r.record("attach %s", opts.Container)
+	done := make(chan struct{})
+	go func() {
+		opts.Success <- struct{}{}
+		<-opts.Success
+		opts.OutputStream.Write([]byte(r.output))
+		close(done)
+	}()
+
+	return fakeCloseWaiter(done), nil
+}
+
+func (r *fakeRuntime) StartContainer(id string, hostConfig *docker.HostConfig) error {
+	r.record("start %s", id)
+	return nil
+}
+
+func (r *fakeRuntime) WaitContainerWithContext(id string, ctx context.Context) (int, error) {
+	r.record("wait %s", id)
+	return r.exitCode, nil
+}
+
+func (r *fakeRuntime) InspectContainer(id string) (*docker.Container, error) {
+	r.record("inspect-container %s", id)
+	state := docker.State{Running: true, Health: docker.Health{Status: r.containers[id]}}
+	return &docker.Container{ID: id, State: state}, nil
+}
+
+func (r *fakeRuntime) ListContainers(opts docker.ListContainersOptions) ([]docker.APIContainers, error) {
+	r.record("list-containers")
+	var containers []docker.APIContainers
+	for name := range r.containers {
+		containers = append(containers, docker.APIContainers{ID: name, Names: []string{"/" + name}})
+	}
+
+	return containers, nil
+}
+
+func (r *fakeRuntime) CreateExec(opts docker.CreateExecOptions) (*docker.Exec, error) {
+	r.record("create-exec %s", opts.Container)
+	r.exec = &opts
+	return &docker.Exec{ID: "e1"}, nil
+}
+
+func (r *fakeRuntime) StartExec(id string, opts docker.StartExecOptions) error {
+	r.record("start-exec %s", id)
+	if opts.InputStream != nil {
+		input, err := io.ReadAll(opts.InputStream)
+		if err != nil {
+			return err
+		}
+
+		r.input = string(input)
+	}
+
+	opts.OutputStream.Write([]byte(r.output))
+	return nil
+}
+
+func (r *fakeRuntime) InspectExec(id string) (*docker.ExecInspect, error) {
+	r.record("inspect-exec %s", id)
+	return &docker.ExecInspect{ID: id, ExitCode: r.exitCode}, nil
+}
+
+func (r *fakeRuntime) RemoveContainer(opts docker.RemoveContainerOptions) error {
+	r.record("remove %s", opts.ID)
+	return nil
+}
+
+type fakeCloseWaiter chan struct{}
+
+func (w fakeCloseWaiter) Wait() error {
+	<-w
+	return nil
+}
+
+func (w fakeCloseWaiter) Close() error {
+	return nil
+}
+
+type SuiteRuntime struct{}
+
+var _ = Suite(&SuiteRuntime{})
+
+func (s *SuiteRuntime) TestRunJobWithFake(c *C) {
+	testcases := []struct {
+		ExitCode int
+		Error    string
+		Removed  bool
+	}{
+		{0, "", true},
+		// the container of a failed execution is kept
+		{2, "error non-zero exit code: 2", false},
+	}
+
+	for _, t := range testcases {
+		runtime := &fakeRuntime{output: "foo\n", exitCode: t.ExitCode}
+
+		job := NewRunJob(runtime)
+		job.Name = "test"
+		job.Image = "busybox"
+		job.Command = "echo foo"
+		job.Pull = "false"
+		job.Delete = "true"
+
+		ctx := &Context{Job: job, Execution: NewExecution()}
+		ctx.Logger = logging.MustGetLogger("chadburn")
+
+		err := job.Run(ctx)
+		if t.Error == "" {
+			c.Assert(err, IsNil)
+		} else {
+			c.Assert(err, ErrorMatches, t.Error)
+		}
+
+		calls := []string{
+			"list-images",
+			"create-container busybox",
+			"inspect-image busybox",
+			"attach c1",
+			"start c1",
+			"wait c1",
+		}
+		if t.Removed {
+			calls = append(calls, "remove c1")
+		}
+
+		c.Assert(runtime.calls, DeepEquals, calls)
+		c.Assert(runtime.created.Config.Cmd, DeepEquals, []string{"echo", "foo"})
+		c.Assert(runtime.created.Config.Labels[LabelJobName], Equals, "test")
+		c.Assert(runtime.created.Config.Env, DeepEquals, executionEnvironment(job, ctx.Execution))
+		assertExecutionVars(c, runtime.created.Config.Env)
+		c.Assert(ctx.Execution.ImageDigest, Equals, "busybox@sha256:5678")
+		c.Assert(strings.TrimSpace(ctx.Execution.OutputStream.String()), Equals, "foo")
+	}
+}
+
+func (s *SuiteRuntime) TestRunJobUnavailable(c *C) {
+	runtime := &fakeRuntime{}
+
+	job := NewRunJob(&healthRuntime{ContainerRuntime: runtime})
+	job.Image = "busybox"
+
+	err := job.Run(&Context{Job: job, Execution: NewExecution()})
+	c.Assert(errors.Is(err, ErrSkippedExecution), Equals, true)
+	c.Assert(runtime.calls, HasLen, 0)
+}
@@ -7,6 +7,7 @@ import (
 	"github.com/prometheus/client_golang/prometheus/promauto"
 	"github.com/robfig/cron/v3"
 	"sync"
+	"time"
 )
 
 var (
@@ -61,12 +62,18 @@ type Scheduler struct {
 	cron      *cron.Cron
 	wg        sync.WaitGroup
 	isRunning bool
+
+	// start time of the last successful execution of every job, by name, so
+	// it is kept when a job is updated
+	lastSuccess   map[string]time.Time
+	lastSuccessMu sync.Mutex
 }
 
 func NewScheduler(l Logger) *Scheduler {
 	cronUtils := NewCronUtils(l)
 	return &Scheduler{
-		Logger: l,
+		Logger:      l,
+		lastSuccess: make(map[string]time.Time),
 		cron: cron.New(cron.WithParser(cron.NewParser(
 			cron.SecondOptional|cron.Minute|cron.Hour|cron.Dom|cron.Month|cron.Dow|cron.Descriptor,
 		)), cron.WithLogger(cronUtils), cron.WithChain(cron.Recover(cronUtils))),
@@ -117,6 +124,28 @@ func (s *Scheduler) IsRunning() bool {
 	return s.isRunning
 }
 
+// LastSuccess returns the start time of the last successful execution of the
+// job with the given name, zero if none
+func (s *Scheduler) LastSuccess(name string) time.Time {
+	s.lastSuccessMu.Lock()
+	defer s.lastSuccessMu.Unlock()
+
+	return s.lastSuccess[name]
+}
+
+func (s *Scheduler) trackSuccess(name string, e *Execution) {
+	if e.Failed || e.Skipped {
+		return
+	}
+
+	s.lastSuccessMu.Lock()
+	defer s.lastSuccessMu.Unlock()
+
+	if e.Date.After(s.lastSuccess[name]) {
+		s.lastSuccess[name] = e.Date
+	}
+}
+
 type jobWrapper struct {
 	s *Scheduler
 	j Job
@@ -127,6 +156,10 @@ func (w *jobWrapper) Run() {
 	defer w.s.wg.Done()
 
 	e := NewExecution()
+	e.Trigger = TriggerCron
+	// cron fires the jobs on the second they are due at
+	e.ScheduledTime = e.ScheduledTime.Truncate(time.Second)
+	e.LastSuccess = w.s.LastSuccess(w.j.GetName())
 	ctx := NewContext(w.s, w.j, e)
 
 	w.start(ctx)
@@ -162,6 +195,7 @@ func (w *jobWrapper) stop(ctx *Context, err error) {
 	if ctx.Execution.Failed {
 		RunErrorsTotal.WithLabelValues(ctx.Job.GetName()).Inc()
 	}
+	w.s.trackSuccess(ctx.Job.GetName(), ctx.Execution)
 	RunLatest.WithLabelValues(ctx.Job.GetName()).SetToCurrentTime()
 	RunDuration.WithLabelValues(ctx.Job.GetName()).Observe(ctx.Execution.Duration.Seconds())
 
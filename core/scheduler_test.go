@@ -55,3 +55,32 @@ func (s *SuiteScheduler) TestMergeMiddlewaresSame(c *C) {
 	c.Assert(m, HasLen, 1)
 	c.Assert(m[0], Equals, mB)
 }
+
+func (s *SuiteScheduler) TestLastSuccess(c *C) {
+	job := &TestJob{}
+	job.Name = "backup"
+
+	sc := NewScheduler(&TestLogger{})
+	c.Assert(sc.LastSuccess("backup").IsZero(), Equals, true)
+
+	first := NewExecution()
+	first.Date = time.Now()
+	sc.trackSuccess("backup", first)
+	c.Assert(sc.LastSuccess("backup"), Equals, first.Date)
+
+	failed := NewExecution()
+	failed.Date = first.Date.Add(time.Minute)
+	failed.Failed = true
+	sc.trackSuccess("backup", failed)
+	c.Assert(sc.LastSuccess("backup"), Equals, first.Date)
+
+	// the executions started before the last success do not replace it
+	previous := NewExecution()
+	previous.Date = first.Date.Add(-time.Minute)
+	sc.trackSuccess("backup", previous)
+	c.Assert(sc.LastSuccess("backup"), Equals, first.Date)
+
+	w := &jobWrapper{sc, job}
+	w.Run()
+	c.Assert(sc.LastSuccess("backup").After(first.Date), Equals, true)
+}
@@ -0,0 +1,34 @@
+package core
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/gobs/args"
+)
+
+// scriptShell returns the command line of the shell running a script, the
+// script is fed through its standard input so it needs no quoting
+func scriptShell(command, shell string) ([]string, error) {
+	if command != "" {
+		return nil, errors.New("command and script can not be used together")
+	}
+
+	cmd := args.GetArgs(shell)
+	if len(cmd) == 0 {
+		return nil, errors.New("shell is required to run a script")
+	}
+
+	return cmd, nil
+}
+
+// joinScript returns the script given as lines, a script given in a single
+// value, e.g. a docker label, is kept as is
+func joinScript(lines []string) string {
+	script := strings.Join(lines, "\n")
+	if !strings.HasSuffix(script, "\n") {
+		script += "\n"
+	}
+
+	return script
+}
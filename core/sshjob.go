@@ -0,0 +1,188 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/user"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+const sshConnectTimeout = 30 * time.Second
+
+// SSHJob runs the command on a remote host through ssh, for the hosts where
+// neither docker nor a cron daemon are available
+type SSHJob struct {
+	BareJob `mapstructure:",squash"`
+	Host    string `hash:"true"`
+	Port    int    `default:"22" hash:"true"`
+	// User defaults to the user running chadburn
+	User string `hash:"true"`
+	// KeyFile is the private key used to authenticate, the keys of the ssh
+	// agent of SSH_AUTH_SOCK are used if empty
+	KeyFile string `gcfg:"key-file" mapstructure:"key-file" hash:"true"`
+	// KnownHosts is the file the key of the host is checked against,
+	// ~/.ssh/known_hosts by default
+	KnownHosts string `gcfg:"known-hosts" mapstructure:"known-hosts" hash:"true"`
+	// StrictHostKeyChecking set to false accepts any host key, it is a string
+	// for the same reason as the Delete option of RunJob
+	StrictHostKeyChecking string `gcfg:"strict-host-key-checking" mapstructure:"strict-host-key-checking" default:"true" hash:"true"`
+	// Template renders the command as a template on every execution, see
+	// TemplateData
+	Template bool `default:"false" hash:"true"`
+}
+
+func NewSSHJob() *SSHJob {
+	return &SSHJob{}
+}
+
+func (j *SSHJob) Run(ctx *Context) error {
+	command, err := renderTemplate(j.Command, newTemplateData(j, ctx.Execution, j.Template))
+	if err != nil {
+		return err
+	}
+
+	auth, closeAuth, err := j.buildAuth()
+	if err != nil {
+		return err
+	}
+	defer closeAuth()
+
+	config, err := j.buildClientConfig(auth)
+	if err != nil {
+		return err
+	}
+
+	addr := net.JoinHostPort(j.Host, strconv.Itoa(j.Port))
+	client, err := ssh.Dial("tcp", addr, config)
+	if err != nil {
+		return fmt.Errorf("error connecting to %s: %s", addr, err)
+	}
+	defer client.Close()
+
+	session, err := client.NewSession()
+	if err != nil {
+		return fmt.Errorf("error creating session: %s", err)
+	}
+	defer session.Close()
+
+	session.Stdout = ctx.Execution.OutputStream
+	session.Stderr = ctx.Execution.ErrorStream
+
+	// the server drops the variables not allowed by its AcceptEnv
+	for _, v := range executionEnvironment(j, ctx.Execution) {
+		name, value, _ := strings.Cut(v, "=")
+		session.Setenv(name, value)
+	}
+
+	err = session.Run(command)
+
+	var exitErr *ssh.ExitError
+	if errors.As(err, &exitErr) {
+		return fmt.Errorf("error non-zero exit code: %d", exitErr.ExitStatus())
+	}
+
+	if err != nil {
+		return fmt.Errorf("error running command: %s", err)
+	}
+
+	return nil
+}
+
+func (j *SSHJob) buildClientConfig(auth ssh.AuthMethod) (*ssh.ClientConfig, error) {
+	if j.Host == "" {
+		return nil, errors.New("host is required")
+	}
+
+	name := j.User
+	if name == "" {
+		u, err := user.Current()
+		if err != nil {
+			return nil, fmt.Errorf("error getting current user: %s", err)
+		}
+
+		name = u.Username
+	}
+
+	hostKeyCallback, err := j.buildHostKeyCallback()
+	if err != nil {
+		return nil, err
+	}
+
+	return &ssh.ClientConfig{
+		User:            name,
+		Auth:            []ssh.AuthMethod{auth},
+		HostKeyCallback: hostKeyCallback,
+		Timeout:         sshConnectTimeout,
+	}, nil
+}
+
+// buildAuth returns the authentication with the key file or the ssh agent,
+// along with a function releasing the connection to the agent
+func (j *SSHJob) buildAuth() (ssh.AuthMethod, func(), error) {
+	if j.KeyFile != "" {
+		key, err := os.ReadFile(j.KeyFile)
+		if err != nil {
+			return nil, nil, fmt.Errorf("error reading key file: %s", err)
+		}
+
+		signer, err := ssh.ParsePrivateKey(key)
+		if err != nil {
+			return nil, nil, fmt.Errorf("error parsing key file %q: %s", j.KeyFile, err)
+		}
+
+		return ssh.PublicKeys(signer), func() {}, nil
+	}
+
+	socket := os.Getenv("SSH_AUTH_SOCK")
+	if socket == "" {
+		return nil, nil, errors.New("no key-file given and no ssh agent available, SSH_AUTH_SOCK is not set")
+	}
+
+	// the agent signs the authentication, it has to be connected until then
+	conn, err := net.Dial("unix", socket)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error connecting to ssh agent: %s", err)
+	}
+
+	closeAuth := func() { conn.Close() }
+	return ssh.PublicKeysCallback(agent.NewClient(conn).Signers), closeAuth, nil
+}
+
+func (j *SSHJob) buildHostKeyCallback() (ssh.HostKeyCallback, error) {
+	if strict, err := strconv.ParseBool(j.StrictHostKeyChecking); err == nil && !strict {
+		return ssh.InsecureIgnoreHostKey(), nil
+	}
+
+	file := j.KnownHosts
+	if file == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, fmt.Errorf("error finding known hosts: %s", err)
+		}
+
+		file = filepath.Join(home, ".ssh", "known_hosts")
+	}
+
+	callback, err := knownhosts.New(file)
+	if err != nil {
+		return nil, fmt.Errorf("error reading known hosts: %s", err)
+	}
+
+	return callback, nil
+}
+
+func (j *SSHJob) Hash() string {
+	var hash string
+	getHash(reflect.TypeOf(j).Elem(), reflect.ValueOf(j).Elem(), &hash)
+	return hash
+}
@@ -0,0 +1,234 @@
+package core
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/pem"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	logging "github.com/op/go-logging"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+	. "gopkg.in/check.v1"
+)
+
+type SuiteSSHJob struct {
+	listener   net.Listener
+	port       int
+	clientKey  ed25519.PrivateKey
+	keyFile    string
+	knownHosts string
+	// commands run by the server, and the variables it received
+	commands []string
+	env      []string
+}
+
+var _ = Suite(&SuiteSSHJob{})
+
+func (s *SuiteSSHJob) SetUpTest(c *C) {
+	s.commands, s.env = nil, nil
+
+	_, hostKey, err := ed25519.GenerateKey(rand.Reader)
+	c.Assert(err, IsNil)
+	hostSigner, err := ssh.NewSignerFromKey(hostKey)
+	c.Assert(err, IsNil)
+
+	_, s.clientKey, err = ed25519.GenerateKey(rand.Reader)
+	c.Assert(err, IsNil)
+	clientSigner, err := ssh.NewSignerFromKey(s.clientKey)
+	c.Assert(err, IsNil)
+
+	config := &ssh.ServerConfig{
+		PublicKeyCallback: func(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if conn.User() == "deploy" && string(key.Marshal()) == string(clientSigner.PublicKey().Marshal()) {
+				return nil, nil
+			}
+
+			return nil, ssh.ErrNoAuth
+		},
+	}
+	config.AddHostKey(hostSigner)
+
+	s.listener, err = net.Listen("tcp", "127.0.0.1:0")
+	c.Assert(err, IsNil)
+	s.port = s.listener.Addr().(*net.TCPAddr).Port
+
+	go s.serve(config)
+
+	dir := c.MkDir()
+	block, err := ssh.MarshalPrivateKey(s.clientKey, "")
+	c.Assert(err, IsNil)
+	s.keyFile = filepath.Join(dir, "id_ed25519")
+	c.Assert(os.WriteFile(s.keyFile, pem.EncodeToMemory(block), 0600), IsNil)
+
+	line := knownhosts.Line([]string{knownhosts.Normalize(s.listener.Addr().String())}, hostSigner.PublicKey())
+	s.knownHosts = filepath.Join(dir, "known_hosts")
+	c.Assert(os.WriteFile(s.knownHosts, []byte(line+"\n"), 0600), IsNil)
+}
+
+func (s *SuiteSSHJob) TearDownTest(c *C) {
+	s.listener.Close()
+}
+
+// serve runs a server answering the env and exec requests, the command
+// `exit N` exits with N, any other is echoed to stdout and stderr
+func (s *SuiteSSHJob) serve(config *ssh.ServerConfig) {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		go func() {
+			_, chans, reqs, err := ssh.NewServerConn(conn, config)
+			if err != nil {
+				return
+			}
+			go ssh.DiscardRequests(reqs)
+
+			for newChannel := range chans {
+				channel, requests, err := newChannel.Accept()
+				if err != nil {
+					return
+				}
+
+				for req := range requests {
+					if req.Type == "env" {
+						var v struct{ Name, Value string }
+						ssh.Unmarshal(req.Payload, &v)
+						s.env = append(s.env, v.Name+"="+v.Value)
+						req.Reply(true, nil)
+						continue
+					}
+
+					if req.Type != "exec" {
+						req.Reply(false, nil)
+						continue
+					}
+
+					command := string(req.Payload[4:])
+					s.commands = append(s.commands, command)
+					req.Reply(true, nil)
+
+					var status uint32
+					if code, ok := strings.CutPrefix(command, "exit "); ok {
+						n, _ := strconv.Atoi(code)
+						status = uint32(n)
+					} else {
+						channel.Write([]byte(command + "\n"))
+						channel.Stderr().Write([]byte("error output\n"))
+					}
+
+					payload := make([]byte, 4)
+					binary.BigEndian.PutUint32(payload, status)
+					channel.SendRequest("exit-status", false, payload)
+					channel.Close()
+				}
+			}
+		}()
+	}
+}
+
+func (s *SuiteSSHJob) newJob(command string) *SSHJob {
+	job := NewSSHJob()
+	job.Name = "test"
+	job.Host = "127.0.0.1"
+	job.Port = s.port
+	job.User = "deploy"
+	job.KeyFile = s.keyFile
+	job.KnownHosts = s.knownHosts
+	job.StrictHostKeyChecking = "true"
+	job.Command = command
+
+	return job
+}
+
+func (s *SuiteSSHJob) newContext(job Job) *Context {
+	ctx := &Context{Job: job, Execution: NewExecution()}
+	ctx.Logger = logging.MustGetLogger("chadburn")
+
+	return ctx
+}
+
+func (s *SuiteSSHJob) TestRun(c *C) {
+	job := s.newJob("uptime")
+	ctx := s.newContext(job)
+
+	c.Assert(job.Run(ctx), IsNil)
+	c.Assert(s.commands, DeepEquals, []string{"uptime"})
+	c.Assert(s.env, DeepEquals, executionEnvironment(job, ctx.Execution))
+	assertExecutionVars(c, s.env)
+	c.Assert(ctx.Execution.OutputStream.String(), Equals, "uptime\n")
+	c.Assert(ctx.Execution.ErrorStream.String(), Equals, "error output\n")
+}
+
+func (s *SuiteSSHJob) TestRunExitCode(c *C) {
+	job := s.newJob("exit 3")
+
+	err := job.Run(s.newContext(job))
+	c.Assert(err, ErrorMatches, "error non-zero exit code: 3")
+}
+
+func (s *SuiteSSHJob) TestRunAgent(c *C) {
+	keyring := agent.NewKeyring()
+	c.Assert(keyring.Add(agent.AddedKey{PrivateKey: s.clientKey}), IsNil)
+
+	socket := filepath.Join(c.MkDir(), "agent.sock")
+	l, err := net.Listen("unix", socket)
+	c.Assert(err, IsNil)
+	defer l.Close()
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go agent.ServeAgent(keyring, conn)
+		}
+	}()
+
+	previous, ok := os.LookupEnv("SSH_AUTH_SOCK")
+	os.Setenv("SSH_AUTH_SOCK", socket)
+	defer func() {
+		if ok {
+			os.Setenv("SSH_AUTH_SOCK", previous)
+		} else {
+			os.Unsetenv("SSH_AUTH_SOCK")
+		}
+	}()
+
+	job := s.newJob("uptime")
+	job.KeyFile = ""
+
+	c.Assert(job.Run(s.newContext(job)), IsNil)
+	c.Assert(s.commands, DeepEquals, []string{"uptime"})
+}
+
+func (s *SuiteSSHJob) TestRunUnknownHostKey(c *C) {
+	c.Assert(os.WriteFile(s.knownHosts, nil, 0600), IsNil)
+
+	job := s.newJob("uptime")
+	err := job.Run(s.newContext(job))
+	c.Assert(err, ErrorMatches, "error connecting to .*: ssh: handshake failed: knownhosts: key is unknown")
+	c.Assert(s.commands, HasLen, 0)
+
+	// unless the checking is disabled
+	job.StrictHostKeyChecking = "false"
+	c.Assert(job.Run(s.newContext(job)), IsNil)
+}
+
+func (s *SuiteSSHJob) TestRunUnauthorized(c *C) {
+	job := s.newJob("uptime")
+	job.User = "root"
+
+	err := job.Run(s.newContext(job))
+	c.Assert(err, ErrorMatches, "error connecting to .*: ssh: handshake failed: ssh: unable to authenticate.*")
+	c.Assert(s.commands, HasLen, 0)
+}
@@ -0,0 +1,122 @@
+package core
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// TemplateData is the data the command, volumes and environment variables of
+// the jobs with templating enabled are rendered with on every execution
+type TemplateData struct {
+	Job         string
+	ExecutionID string
+	Hostname    string
+	// ScheduledTime is the time the execution was due at, LastSuccess the
+	// start time of the last successful execution of the job, zero if none
+	ScheduledTime time.Time
+	LastSuccess   time.Time
+}
+
+// templateFuncs are the helpers available to the templates, e.g.
+// `{{ date "2006-01-02" .ScheduledTime }}` or
+// `{{ .ScheduledTime | add "-24h" | date "20060102" }}`
+var templateFuncs = template.FuncMap{
+	"date": func(layout string, t time.Time) string {
+		return t.Format(layout)
+	},
+	"utc": func(t time.Time) time.Time {
+		return t.UTC()
+	},
+	"unix": func(t time.Time) int64 {
+		return t.Unix()
+	},
+	"add": func(duration string, t time.Time) (time.Time, error) {
+		d, err := time.ParseDuration(duration)
+		if err != nil {
+			return t, err
+		}
+
+		return t.Add(d), nil
+	},
+}
+
+// newTemplateData returns the data to render the values of the job with, nil
+// if templating is not enabled, which leaves them untouched
+func newTemplateData(j Job, e *Execution, enabled bool) *TemplateData {
+	if !enabled {
+		return nil
+	}
+
+	hostname, _ := os.Hostname()
+	return &TemplateData{
+		Job:           j.GetName(),
+		ExecutionID:   e.ID,
+		Hostname:      hostname,
+		ScheduledTime: e.ScheduledTime,
+		LastSuccess:   e.LastSuccess,
+	}
+}
+
+func parseTemplate(text string) (*template.Template, error) {
+	return template.New("").Funcs(templateFuncs).Option("missingkey=error").Parse(text)
+}
+
+// ValidateTemplate checks that the template can be rendered, text without
+// actions is always valid
+func ValidateTemplate(text string) error {
+	if !strings.Contains(text, "{{") {
+		return nil
+	}
+
+	t, err := parseTemplate(text)
+	if err != nil {
+		return fmt.Errorf("invalid template %q: %s", text, err)
+	}
+
+	data := &TemplateData{Job: "job", ExecutionID: "000000000000", Hostname: "localhost", ScheduledTime: time.Now()}
+	if err := t.Execute(&strings.Builder{}, data); err != nil {
+		return fmt.Errorf("invalid template %q: %s", text, err)
+	}
+
+	return nil
+}
+
+// renderTemplate returns the text rendered with the data, text without
+// actions, or without data, is returned as is
+func renderTemplate(text string, data *TemplateData) (string, error) {
+	if data == nil || !strings.Contains(text, "{{") {
+		return text, nil
+	}
+
+	t, err := parseTemplate(text)
+	if err != nil {
+		return "", fmt.Errorf("error rendering template %q: %s", text, err)
+	}
+
+	var b strings.Builder
+	if err := t.Execute(&b, data); err != nil {
+		return "", fmt.Errorf("error rendering template %q: %s", text, err)
+	}
+
+	return b.String(), nil
+}
+
+// renderTemplates renders every value with the data
+func renderTemplates(values []string, data *TemplateData) ([]string, error) {
+	if len(values) == 0 {
+		return values, nil
+	}
+
+	rendered := make([]string, len(values))
+	for i, v := range values {
+		var err error
+		if rendered[i], err = renderTemplate(v, data); err != nil {
+			return nil, err
+		}
+	}
+
+	return rendered, nil
+}
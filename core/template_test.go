@@ -0,0 +1,56 @@
+package core
+
+import (
+	"os"
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+type SuiteTemplate struct{}
+
+var _ = Suite(&SuiteTemplate{})
+
+func (s *SuiteTemplate) TestRenderTemplate(c *C) {
+	hostname, _ := os.Hostname()
+	data := &TemplateData{
+		Job:           "backup",
+		ExecutionID:   "4ab602119927",
+		Hostname:      hostname,
+		ScheduledTime: time.Date(2026, 10, 16, 3, 0, 0, 0, time.FixedZone("CEST", 2*60*60)),
+		LastSuccess:   time.Date(2026, 10, 15, 1, 0, 0, 0, time.UTC),
+	}
+
+	testcases := []struct {
+		Template string
+		Expected string
+	}{
+		{"pg_dump -f /backups/dump.sql", "pg_dump -f /backups/dump.sql"},
+		{`pg_dump -f /backups/dump-{{ date "2006-01-02" .ScheduledTime }}.sql`, "pg_dump -f /backups/dump-2026-10-16.sql"},
+		{`{{ .ScheduledTime | utc | date "15:04" }}`, "01:00"},
+		{`{{ .ScheduledTime | add "-24h" | date "20060102" }}`, "20261015"},
+		{`--since {{ unix .LastSuccess }}`, "--since 1792026000"},
+		{`{{ .Job }}-{{ .ExecutionID }}@{{ .Hostname }}`, "backup-4ab602119927@" + hostname},
+	}
+
+	for _, t := range testcases {
+		rendered, err := renderTemplate(t.Template, data)
+		c.Assert(err, IsNil, Commentf(t.Template))
+		c.Assert(rendered, Equals, t.Expected)
+		c.Assert(ValidateTemplate(t.Template), IsNil)
+	}
+
+	values, err := renderTemplates([]string{"JOB={{ .Job }}", "FOO=bar"}, data)
+	c.Assert(err, IsNil)
+	c.Assert(values, DeepEquals, []string{"JOB=backup", "FOO=bar"})
+
+	_, err = renderTemplate(`{{ add "1 day" .ScheduledTime }}`, data)
+	c.Assert(err, ErrorMatches, `error rendering template .*error calling add: .*`)
+}
+
+func (s *SuiteTemplate) TestValidateTemplate(c *C) {
+	c.Assert(ValidateTemplate(`{{ .Foo }}`), ErrorMatches, `invalid template "{{ .Foo }}": .*can't evaluate field Foo.*`)
+	c.Assert(ValidateTemplate(`{{ strftime "%F" .ScheduledTime }}`), ErrorMatches, `invalid template .*function "strftime" not defined`)
+	c.Assert(ValidateTemplate(`{{ .Job`), ErrorMatches, `invalid template .*unclosed action`)
+	c.Assert(ValidateTemplate(`docker ps --format '{{"{{"}}.Names{{"}}"}}'`), IsNil)
+}
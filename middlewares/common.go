@@ -1,6 +1,11 @@
 package middlewares
 
-import "reflect"
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/PremoWeb/Chadburn/core"
+)
 
 func IsEmpty(i interface{}) bool {
 	t := reflect.TypeOf(i).Elem()
@@ -8,3 +13,14 @@ func IsEmpty(i interface{}) bool {
 
 	return reflect.DeepEqual(i, e)
 }
+
+// shouldReport returns true if the execution has to be reported, executions
+// using a new image are reported even if only errors are
+func shouldReport(e *core.Execution, onlyOnError bool) bool {
+	return e.Failed || e.ImageChanged() || !onlyOnError
+}
+
+// imageChangedMessage describes the change of image of the execution
+func imageChangedMessage(e *core.Execution) string {
+	return fmt.Sprintf("Image changed from %s to %s", e.PreviousImageDigest, e.ImageDigest)
+}
@@ -38,7 +38,7 @@ func (m *Gotify) Run(ctx *core.Context) error {
 	err := ctx.Next()
 	ctx.Stop(err)
 
-	if ctx.Execution.Failed || !m.GotifyOnlyOnError {
+	if shouldReport(ctx.Execution, m.GotifyOnlyOnError) {
 		m.pushMessage(ctx)
 	}
 
@@ -69,6 +69,10 @@ func (m *Gotify) buildMessage(ctx *core.Context) *gotifyMessage {
 	} else if ctx.Execution.Skipped {
 		msg.Message = "Skipped: " + msg.Message
 	}
+
+	if ctx.Execution.ImageChanged() {
+		msg.Message += "\n\n" + imageChangedMessage(ctx.Execution)
+	}
 	return msg
 }
 
@@ -53,7 +53,7 @@ func (m *Mail) Run(ctx *core.Context) error {
 	err := ctx.Next()
 	ctx.Stop(err)
 
-	if ctx.Execution.Failed || !m.MailOnlyOnError {
+	if shouldReport(ctx.Execution, m.MailOnlyOnError) {
 		err := m.sendMail(ctx)
 		if err != nil {
 			ctx.Logger.Errorf("Mail error: %q", err)
@@ -145,6 +145,11 @@ func init() {
 			Execution <b>{{status .Execution}}</b> in ​<b>{{.Execution.Duration}}</b>​,
 			command: ​<pre>{{.Job.GetCommand}}</pre>​
 		</p>
+		{{- if .Execution.ImageChanged}}
+		<p>
+			Image changed from <b>{{.Execution.PreviousImageDigest}}</b> to <b>{{.Execution.ImageDigest}}</b>
+		</p>
+		{{- end}}
   `))
 
 	template.Must(mailSubjectTemplate.Parse(
@@ -44,7 +44,7 @@ func (m *Save) Run(ctx *core.Context) error {
 	err := ctx.Next()
 	ctx.Stop(err)
 
-	if ctx.Execution.Failed || !m.SaveOnlyOnError {
+	if shouldReport(ctx.Execution, m.SaveOnlyOnError) {
 		err := m.saveToDisk(ctx)
 		if err != nil {
 			ctx.Logger.Errorf("Save error: %q", err)
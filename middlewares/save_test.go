@@ -1,6 +1,7 @@
 package middlewares
 
 import (
+	"errors"
 	"io/ioutil"
 	"os"
 	"path/filepath"
@@ -58,3 +59,22 @@ func (s *SuiteSave) TestRunSuccessOnError(c *C) {
 	_, err = os.Stat(filepath.Join(dir, "00010101_000000_foo.json"))
 	c.Assert(err, Not(IsNil))
 }
+
+func (s *SuiteSave) TestRunJobExecContextTargets(c *C) {
+	dir, err := ioutil.TempDir("/tmp", "save")
+	c.Assert(err, IsNil)
+
+	s.ctx.Start()
+	s.ctx.Execution.AddTarget("task 1", time.Second, errors.New("error non-zero exit code: 2"))
+	s.ctx.Stop(nil)
+
+	s.job.Name = "foo"
+	s.ctx.Execution.Date = time.Time{}
+
+	m := NewSave(&SaveConfig{SaveFolder: dir, SaveJobExecContext: true})
+	c.Assert(m.Run(s.ctx), IsNil)
+
+	js, err := ioutil.ReadFile(filepath.Join(dir, "foo_00010101.json"))
+	c.Assert(err, IsNil)
+	c.Assert(string(js), Matches, `(?s).*"Target": "task 1",.*"Failed": true,\s*"Error": "error non-zero exit code: 2".*`)
+}
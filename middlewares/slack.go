@@ -47,7 +47,7 @@ func (m *Slack) Run(ctx *core.Context) error {
 	err := ctx.Next()
 	ctx.Stop(err)
 
-	if ctx.Execution.Failed || !m.SlackOnlyOnError {
+	if shouldReport(ctx.Execution, m.SlackOnlyOnError) {
 		m.pushMessage(ctx)
 	}
 
@@ -85,10 +85,17 @@ func (m *Slack) buildMessage(ctx *core.Context) *slackMessage {
 			Color: "#F35A00",
 		})
 	} else if ctx.Execution.Skipped {
-		msg.Attachments = append(msg.Attachments, slackAttachment{
+		attachment := slackAttachment{
 			Title: "Execution skipped",
 			Color: "#FFA500",
-		})
+		}
+
+		// the error of a skipped execution holds the reason of the skip
+		if ctx.Execution.Error != nil {
+			attachment.Text = ctx.Execution.Error.Error()
+		}
+
+		msg.Attachments = append(msg.Attachments, attachment)
 	} else {
 		msg.Attachments = append(msg.Attachments, slackAttachment{
 			Title: "Execution successful",
@@ -96,6 +103,14 @@ func (m *Slack) buildMessage(ctx *core.Context) *slackMessage {
 		})
 	}
 
+	if ctx.Execution.ImageChanged() {
+		msg.Attachments = append(msg.Attachments, slackAttachment{
+			Title: "Image changed",
+			Text:  imageChangedMessage(ctx.Execution),
+			Color: "#0076D7",
+		})
+	}
+
 	return msg
 }
 
@@ -64,3 +64,25 @@ func (s *SuiteSlack) TestRunSuccessOnError(c *C) {
 	m := NewSlack(&SlackConfig{SlackWebhook: ts.URL, SlackOnlyOnError: true})
 	c.Assert(m.Run(s.ctx), IsNil)
 }
+
+func (s *SuiteSlack) TestRunImageChangedOnError(c *C) {
+	var called bool
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var m slackMessage
+		json.Unmarshal([]byte(r.FormValue(slackPayloadVar)), &m)
+		c.Assert(m.Attachments[1].Title, Equals, "Image changed")
+		c.Assert(m.Attachments[1].Text, Equals, "Image changed from foo@sha256:1 to foo@sha256:2")
+		called = true
+	}))
+
+	defer ts.Close()
+
+	s.ctx.Start()
+	s.ctx.Execution.ImageDigest = "foo@sha256:2"
+	s.ctx.Execution.PreviousImageDigest = "foo@sha256:1"
+	s.ctx.Stop(nil)
+
+	m := NewSlack(&SlackConfig{SlackWebhook: ts.URL, SlackOnlyOnError: true})
+	c.Assert(m.Run(s.ctx), IsNil)
+	c.Assert(called, Equals, true)
+}
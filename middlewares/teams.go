@@ -46,7 +46,7 @@ func (m *Teams) Run(ctx *core.Context) error {
 	err := ctx.Next()
 	ctx.Stop(err)
 
-	if ctx.Execution.Failed || !m.TeamsOnlyOnError {
+	if shouldReport(ctx.Execution, m.TeamsOnlyOnError) {
 		m.pushMessage(ctx)
 	}
 
@@ -90,6 +90,16 @@ func (m *Teams) buildMessage(ctx *core.Context) *teamsMessage {
 		msg.ThemeColor = "FFA500"
 		msg.Summary = "Execution skipped"
 		s1.ActivitySubtitle = fmt.Sprintf("Execution skipped")
+		if ctx.Execution.Error != nil {
+			s1.ActivitySubtitle = fmt.Sprintf("Execution skipped: %v", ctx.Execution.Error.Error())
+		}
+	}
+
+	if ctx.Execution.ImageChanged() {
+		s1.Facts = append(s1.Facts, teamsMessageSectionFact{
+			Name:  "Image changed",
+			Value: fmt.Sprintf("%s → %s", ctx.Execution.PreviousImageDigest, ctx.Execution.ImageDigest),
+		})
 	}
 
 	msg.Sections = append(msg.Sections, s1)